@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Acceptance records files whose current contents were accepted as the new
+// baseline, for the audit log kept with a path's manifests.
+type Acceptance struct {
+	AcceptedAt time.Time `json:"accepted_at"`
+	User       string    `json:"user"`
+	Host       string    `json:"host"`
+	Note       string    `json:"note,omitempty"`
+	// Algorithm of the checksums below
+	Algorithm string         `json:"algorithm"`
+	Files     []AcceptedFile `json:"files"`
+}
+
+// AcceptedFile is a file whose checksum was replaced by an acceptance.
+type AcceptedFile struct {
+	Path string `json:"path"`
+	// Empty if the previous manifest has no checksum for the file, e.g. because
+	// it couldn't be read
+	OldChecksum string `json:"old_checksum"`
+	NewChecksum string `json:"new_checksum"`
+}
+
+// AcceptFiles re-hashes files, given relative to the manifest's path, and
+// returns a copy of the manifest with only their entries updated, along with a
+// record of the changes. The files must already be in the manifest.
+func AcceptFiles(baseline *Manifest, relPaths []string) (*Manifest, *Acceptance, error) {
+	createdAt := time.Now().UTC()
+	algorithms := append([]string{baseline.HashAlgorithm()}, baseline.AltAlgorithms...)
+	manifest := &Manifest{
+		Path:          baseline.Path,
+		CreatedAt:     createdAt,
+		Algorithm:     baseline.Algorithm,
+		AltAlgorithms: baseline.AltAlgorithms,
+		ChunkSize:     baseline.ChunkSize,
+		Excludes:      baseline.Excludes,
+		Entries:       map[string]ChecksumRecord{},
+	}
+	for relPath, entry := range baseline.Entries {
+		manifest.Entries[relPath] = entry
+	}
+	acceptance := &Acceptance{
+		AcceptedAt: createdAt,
+		User:       currentUsername(),
+		Algorithm:  baseline.HashAlgorithm(),
+	}
+	acceptance.Host, _ = os.Hostname()
+	// Symlinks are only accepted through when the manifest follows them
+	followed := baseline.followedSymlinks()
+	stat := os.Lstat
+	if followed {
+		stat = os.Stat
+	}
+
+	for _, relPath := range relPaths {
+		relPath = norm.NFC.String(relPath)
+		path := filepath.Join(baseline.Path, relPath)
+		if _, ok := baseline.Entries[relPath]; !ok {
+			return nil, nil, fmt.Errorf("%s is not in the manifest", path)
+		}
+		info, err := stat(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil, nil, fmt.Errorf("%s is a symlink, which the manifest doesn't follow", path)
+		}
+		if !info.Mode().IsRegular() {
+			return nil, nil, fmt.Errorf("%s is not a regular file", path)
+		}
+		checksums, chunks, err := hashFile(path, algorithms, hashOptions{chunkSize: baseline.ChunkSize})
+		if err != nil {
+			return nil, nil, err
+		}
+		record := newChecksumRecord(info, algorithms, checksums)
+		record.Chunks = chunks
+		if followed {
+			// Accepted through the same symlink, if it was found through one
+			record.LinkTarget = baseline.Entries[relPath].LinkTarget
+		}
+		record.LastVerified = createdAt
+		manifest.Entries[relPath] = record
+		acceptance.Files = append(acceptance.Files, AcceptedFile{
+			Path:        relPath,
+			OldChecksum: baseline.Entries[relPath].Checksum,
+			NewChecksum: record.Checksum,
+		})
+	}
+	return manifest, acceptance, nil
+}
+
+func currentUsername() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
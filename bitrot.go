@@ -6,7 +6,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 const (
@@ -24,26 +26,221 @@ type ComparedPathArguments struct {
 	New flags.Filename `positional-arg-name:"NEWPATH" description:"Path to new or copy directory."`
 }
 
+type AcceptArguments struct {
+	Root  flags.Filename   `positional-arg-name:"ROOT" description:"Path to tracked directory."`
+	Paths []flags.Filename `positional-arg-name:"PATH" required:"1" description:"Files to accept."`
+}
+
+type VoteArguments struct {
+	Paths []flags.Filename `positional-arg-name:"PATH" required:"2" description:"Paths to replicas of a directory."`
+}
+
+// Options limiting how much a run loads the system, for commands that hash a
+// directory
+type RunLimits struct {
+	MaxReadRate       float64       `long:"max-read-rate" value-name:"MIB/S" description:"Read files at most this many mebibytes (MiB) per second, in total across jobs."`
+	MaxFilesPerSecond float64       `long:"max-files-per-second" value-name:"N" description:"Open at most this many files per second."`
+	IOPriority        string        `long:"io-priority" choice:"idle" choice:"best-effort" description:"Lower the I/O scheduling class of the run (Linux only): idle reads only when nothing else wants the disk."`
+	Nice              int           `long:"nice" value-name:"N" description:"CPU niceness for the run (Linux only)."`
+	FileTimeout       time.Duration `long:"file-timeout" value-name:"DURATION" description:"Give up on a file whose reads make no progress for this long, e.g. 5m, and record it as unreadable, along with other files on its device while the read stays stuck. Time waiting on read limits doesn't count. For hung network mounts."`
+	DirTimeout        time.Duration `long:"dir-timeout" value-name:"DURATION" description:"Give up on a directory not listed within this long, e.g. 1m, and record it as unreadable."`
+	// Priority already set for the process, by a run on an earlier root
+	priority *processPriority
+}
+
+// processPriority is the I/O priority and niceness of the process, which
+// apply to every root in an invocation.
+type processPriority struct {
+	ioPriority string
+	nice       int
+}
+
+// apply sets up throttling and priority for a run on a root, using the root's
+// settings for limits not given on the command line, and logs the limits. The
+// priority is set once per invocation, so a root that asks for a different one
+// than an earlier root is an error.
+func (limits *RunLimits) apply(config *Config, root RootConfig, logger *log.Logger) error {
+	maxReadRate := limits.MaxReadRate
+	if maxReadRate == 0 {
+		maxReadRate = root.MaxReadRate
+	}
+	maxFilesPerSecond := limits.MaxFilesPerSecond
+	if maxFilesPerSecond == 0 {
+		maxFilesPerSecond = root.MaxFilesPerSecond
+	}
+	config.Throttle = NewThrottle(maxReadRate*1024*1024, maxFilesPerSecond)
+	config.FileTimeout = limits.FileTimeout
+	if config.FileTimeout == 0 {
+		config.FileTimeout = root.FileTimeout
+	}
+	config.DirTimeout = limits.DirTimeout
+	if config.DirTimeout == 0 {
+		config.DirTimeout = root.DirTimeout
+	}
+
+	ioPriority := limits.IOPriority
+	if ioPriority == "" {
+		ioPriority = root.IOPriority
+	}
+	nice := limits.Nice
+	if nice == 0 {
+		nice = root.Nice
+	}
+	priority := processPriority{ioPriority: ioPriority, nice: nice}
+	if limits.priority == nil {
+		err := setPriority(ioPriority, nice)
+		if err != nil {
+			return err
+		}
+		limits.priority = &priority
+	} else if *limits.priority != priority {
+		return fmt.Errorf("I/O priority and niceness apply to the whole process and were already set for an earlier root; run this root separately")
+	}
+
+	settings := []string{}
+	if config.Throttle != nil {
+		settings = append(settings, fmt.Sprintf("reading at most %s", config.Throttle))
+	}
+	if config.FileTimeout > 0 {
+		settings = append(settings, fmt.Sprintf("giving up on files stalled for %s", config.FileTimeout))
+	}
+	if config.DirTimeout > 0 {
+		settings = append(settings, fmt.Sprintf("giving up on directories after %s", config.DirTimeout))
+	}
+	if ioPriority != "" {
+		settings = append(settings, fmt.Sprintf("%s I/O priority", ioPriority))
+	}
+	if nice != 0 {
+		settings = append(settings, fmt.Sprintf("niceness %d", nice))
+	}
+	if len(settings) > 0 {
+		logger.Printf("Limits: %s.\n", strings.Join(settings, ", "))
+	}
+	return nil
+}
+
+// throttleSummary reports how much a run was slowed by its read limits.
+func throttleSummary(throttle *Throttle) string {
+	return fmt.Sprintf("Waited %s for read limits (%s).\n", throttle.Waited().Round(time.Second), throttle)
+}
+
 // Options/arguments for the `generate` command
 type Generate struct {
-	Exclude   []string      `short:"e" long:"exclude" description:"File/directory names to exclude. Repeat option to exclude multiple names."`
-	Pretty    bool          `short:"p" long:"pretty" description:"Make a \"pretty\" (indented) JSON file."`
-	Arguments PathArguments `required:"true" positional-args:"true"`
+	Exclude        []string `short:"e" long:"exclude" description:"File/directory names to exclude, replacing those used for the previous manifest. Repeat option to exclude multiple names."`
+	AddExclude     []string `long:"add-exclude" description:"File/directory name to exclude in addition to those used for the previous manifest. Repeat option to add multiple names."`
+	RemoveExclude  []string `long:"remove-exclude" description:"File/directory name to stop excluding. Repeat option to remove multiple names."`
+	Pretty         bool     `short:"p" long:"pretty" description:"Make a \"pretty\" (indented) JSON file."`
+	Hash           string   `long:"hash" choice:"sha1" choice:"sha256" choice:"sha512" choice:"blake2b" choice:"crc64" description:"Hash algorithm for checksums. Defaults to the algorithm of the previous manifest, or sha1."`
+	Jobs           int      `short:"j" long:"jobs" description:"Number of files to hash in parallel on each solid-state device (spinning disks are read one file at a time). Defaults to the number of CPUs."`
+	Quick          bool     `short:"q" long:"quick" description:"Skip re-hashing files whose size, times and inode match the previous manifest."`
+	FollowSymlinks bool     `long:"follow-symlinks" description:"Hash the files symlinks point to and walk linked directories, instead of only recording link targets. Each file is hashed once however many paths lead to it, and links that loop aren't followed."`
+	Rereads        int      `long:"rereads" value-name:"N" description:"Re-read flagged files N times, bypassing the cache where possible, to tell corrupted data from inconsistent reads. Defaults to 2; use -1 to skip."`
+	Resume         bool     `long:"resume" description:"Continue from the checkpoint of an interrupted run."`
+	BypassCache    string   `long:"bypass-cache" choice:"direct" choice:"evict" description:"Avoid reading through the page cache, so files are read from the disk: with direct I/O (falling back to evict where unsupported), or by evicting each file's pages before and after hashing it."`
+	ChunkSize      ByteSize `long:"chunk-size" value-name:"SIZE" description:"Also hash each chunk of this size (e.g. 1M) within files, so that flagged files report which byte ranges changed. Defaults to the chunk size of the previous manifest."`
+	Parity         int      `long:"parity" optional:"yes" optional-value:"10" value-name:"PERCENT" description:"Store Reed-Solomon parity for each file, so small corruptions can be healed. PERCENT is the redundancy (default 10)."`
+	AcceptFlagged  bool     `long:"accept-flagged" description:"Record new checksums for files flagged as possibly corrupted, instead of keeping their last known-good checksums."`
+	All            bool     `short:"a" long:"all" description:"Process every root declared in the config file instead of PATH."`
+	RunLimits
+	Arguments PathArguments `positional-args:"true"`
 	logger    *log.Logger
+	options   *GlobalOptions
 }
 
 // Options/arguments for the `validate` command
 type Validate struct {
-	Exclude   []string      `short:"e" long:"exclude" description:"File/directory names to exclude. Repeat option to exclude multiple names."`
+	Exclude        []string `short:"e" long:"exclude" description:"File/directory names to exclude, replacing those used for the previous manifest. Repeat option to exclude multiple names."`
+	AddExclude     []string `long:"add-exclude" description:"File/directory name to exclude in addition to those used for the previous manifest. Repeat option to add multiple names."`
+	RemoveExclude  []string `long:"remove-exclude" description:"File/directory name to stop excluding. Repeat option to remove multiple names."`
+	Hash           string   `long:"hash" choice:"sha1" choice:"sha256" choice:"sha512" choice:"blake2b" choice:"crc64" description:"Hash algorithm for checksums. Defaults to the algorithm of the previous manifest, or sha1."`
+	Jobs           int      `short:"j" long:"jobs" description:"Number of files to hash in parallel on each solid-state device (spinning disks are read one file at a time). Defaults to the number of CPUs."`
+	Quick          bool     `short:"q" long:"quick" description:"Skip re-hashing files whose size, times and inode match the previous manifest."`
+	FollowSymlinks bool     `long:"follow-symlinks" description:"Hash the files symlinks point to and walk linked directories, instead of only recording link targets. Each file is hashed once however many paths lead to it, and links that loop aren't followed."`
+	Rereads        int      `long:"rereads" value-name:"N" description:"Re-read flagged files N times, bypassing the cache where possible, to tell corrupted data from inconsistent reads. Defaults to 2; use -1 to skip."`
+	Resume         bool     `long:"resume" description:"Continue from the checkpoint of an interrupted run."`
+	BypassCache    string   `long:"bypass-cache" choice:"direct" choice:"evict" description:"Avoid reading through the page cache, so files are read from the disk: with direct I/O (falling back to evict where unsupported), or by evicting each file's pages before and after hashing it."`
+	All            bool     `short:"a" long:"all" description:"Process every root declared in the config file instead of PATH."`
+	RunLimits
+	Arguments PathArguments `positional-args:"true"`
+	logger    *log.Logger
+	options   *GlobalOptions
+}
+
+// Options/arguments for the `scrub` command
+type Scrub struct {
+	MaxDuration time.Duration `long:"max-duration" description:"Stop verifying files after this long, e.g. 2h30m."`
+	MaxBytes    ByteSize      `long:"max-bytes" description:"Stop verifying files after reading this much, e.g. 500G."`
+	CycleDays   int           `long:"cycle-days" default:"30" description:"Days in which every file should be verified. Without other limits, each run reads enough to verify everything once per cycle if run daily."`
+	Rereads     int           `long:"rereads" value-name:"N" description:"Re-read flagged files N times, bypassing the cache where possible, to tell corrupted data from inconsistent reads. Defaults to 2; use -1 to skip."`
+	BypassCache string        `long:"bypass-cache" choice:"direct" choice:"evict" description:"Avoid reading through the page cache, so files are read from the disk: with direct I/O (falling back to evict where unsupported), or by evicting each file's pages before and after hashing it."`
+	Arguments   PathArguments `required:"true" positional-args:"true"`
+	logger      *log.Logger
+	options     *GlobalOptions
+}
+
+// Options/arguments for the `accept` command
+type Accept struct {
+	Note      string          `short:"m" long:"note" description:"Reason for accepting the files, recorded in the audit log."`
+	Arguments AcceptArguments `required:"true" positional-args:"true"`
+	logger    *log.Logger
+	options   *GlobalOptions
+}
+
+// Options/arguments for the `repair` command
+type Repair struct {
+	From      flags.Filename `long:"from" value-name:"REPLICA" required:"true" description:"Directory holding a copy of PATH to restore files from."`
+	Deleted   bool           `long:"deleted" description:"Also restore files deleted since the latest manifest."`
+	DryRun    bool           `short:"n" long:"dry-run" description:"Check the replica without restoring anything."`
+	Jobs      int            `short:"j" long:"jobs" description:"Number of files to hash in parallel on each solid-state device (spinning disks are read one file at a time). Defaults to the number of CPUs."`
+	Arguments PathArguments  `required:"true" positional-args:"true"`
+	logger    *log.Logger
+	options   *GlobalOptions
+}
+
+// Options/arguments for the `heal` command
+type Heal struct {
+	DryRun    bool          `short:"n" long:"dry-run" description:"Check which files can be healed without changing anything."`
+	Jobs      int           `short:"j" long:"jobs" description:"Number of files to hash in parallel on each solid-state device (spinning disks are read one file at a time). Defaults to the number of CPUs."`
 	Arguments PathArguments `required:"true" positional-args:"true"`
 	logger    *log.Logger
+	options   *GlobalOptions
+}
+
+// Options/arguments for the `incidents` command
+type Incidents struct {
+	Open      bool          `long:"open" description:"Only list incidents that haven't been resolved."`
+	Arguments PathArguments `positional-args:"true"`
+	logger    *log.Logger
+	options   *GlobalOptions
+}
+
+// Options/arguments for the `check-ignore` command
+type CheckIgnore struct {
+	Root      flags.Filename `long:"root" description:"Directory whose ignore rules apply. Defaults to the tracked directory containing PATH."`
+	Arguments PathArguments  `required:"true" positional-args:"true"`
+	logger    *log.Logger
+	options   *GlobalOptions
 }
 
 // Options/arguments for the `compare` command
 type Compare struct {
 	Exclude   []string              `short:"e" long:"exclude" description:"File/directory names to exclude. Repeat option to exclude multiple names."`
+	Hash      string                `long:"hash" choice:"sha1" choice:"sha256" choice:"sha512" choice:"blake2b" choice:"crc64" default:"sha1" description:"Hash algorithm for checksums."`
+	Jobs      int                   `short:"j" long:"jobs" description:"Number of files to hash in parallel on each solid-state device (spinning disks are read one file at a time). Defaults to the number of CPUs."`
 	Arguments ComparedPathArguments `required:"true" positional-args:"true"`
 	logger    *log.Logger
+	options   *GlobalOptions
+}
+
+// Options/arguments for the `vote` command
+type Vote struct {
+	Exclude   []string      `short:"e" long:"exclude" description:"File/directory names to exclude. Repeat option to exclude multiple names."`
+	Hash      string        `long:"hash" choice:"sha1" choice:"sha256" choice:"sha512" choice:"blake2b" choice:"crc64" description:"Hash algorithm for checksums. Defaults to the algorithm of the first replica's stored manifest, or sha1."`
+	Jobs      int           `short:"j" long:"jobs" description:"Number of files to hash in parallel on each solid-state device (spinning disks are read one file at a time). Defaults to the number of CPUs."`
+	Plan      bool          `long:"plan" description:"List copies that would replace likely corrupt files."`
+	Arguments VoteArguments `required:"true" positional-args:"true"`
+	logger    *log.Logger
+	options   *GlobalOptions
 }
 
 // Options/arguments for the `compare-latest-manifests` command
@@ -51,6 +248,15 @@ type CompareLatestManifests struct {
 	Exclude   []string              `short:"e" long:"exclude" description:"File/directory names to exclude. Repeat option to exclude multiple names."`
 	Arguments ComparedPathArguments `required:"true" positional-args:"true"`
 	logger    *log.Logger
+	options   *GlobalOptions
+}
+
+// Like pathString, but the path must have been given
+func requiredPathString(name flags.Filename) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("the required argument `PATH` was not provided (or use --all)")
+	}
+	return pathString(name)
 }
 
 // Extracts string path from wrapper and converts it to an absolute path
@@ -62,17 +268,102 @@ func pathString(name flags.Filename) (string, error) {
 	return path, nil
 }
 
+// rereadCount resolves how many times to re-read flagged files from a command
+// line option and a root's settings.
+func rereadCount(option int, root RootConfig) int {
+	if option != 0 {
+		return option
+	}
+	if root.Rereads != 0 {
+		return root.Rereads
+	}
+	return defaultRereads
+}
+
+// logFailures logs the problems that fail a comparison, and reports whether
+// there were any.
+func logFailures(logger *log.Logger, comparison *ManifestComparison) bool {
+	if flagged := len(comparison.FlaggedPaths); flagged > 0 {
+		logger.Printf("%d files flagged for possible corruption.", flagged)
+		if inconsistent := len(comparison.InconsistentPaths()); inconsistent > 0 {
+			logger.Printf("%d of them read inconsistently; check the hardware before restoring data.\n", inconsistent)
+		}
+	}
+	if unreadable := len(comparison.UnreadablePaths); unreadable > 0 {
+		logger.Printf("%d files could not be read.\n", unreadable)
+	}
+	return !comparison.Success()
+}
+
 func (cmd *Generate) Execute(args []string) (err error) {
-	config := DefaultConfig()
-	if len(cmd.Exclude) > 0 {
-		config.ExcludedFiles = cmd.Exclude
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
 	}
 	assertNoExtraArgs(&args, cmd.logger)
-	path, err := pathString(cmd.Arguments.Path)
+	if cmd.All {
+		return forEachRoot(config, cmd.logger, cmd.generate)
+	}
+	path, err := requiredPathString(cmd.Arguments.Path)
 	if err != nil {
 		return err
 	}
+	return cmd.generate(path, config)
+}
+
+func (cmd *Generate) generate(path string, config *Config) (err error) {
+	root := config.rootSettings(path)
+	config.HashAlgorithm = cmd.Hash
+	if config.HashAlgorithm == "" {
+		config.HashAlgorithm = root.Hash
+	}
+	config.Jobs = cmd.Jobs
+	if config.Jobs == 0 {
+		config.Jobs = root.Jobs
+	}
+	config.Quick = cmd.Quick || root.Quick
+	config.FollowSymlinks = cmd.FollowSymlinks || root.FollowSymlinks
+	config.CacheMode = cmd.BypassCache
+	if config.CacheMode == "" {
+		config.CacheMode = root.BypassCache
+	}
+	err = cmd.RunLimits.apply(config, root, cmd.logger)
+	if err != nil {
+		return err
+	}
+	config.ChunkSize = cmd.ChunkSize
+	if config.ChunkSize == 0 {
+		config.ChunkSize = root.ChunkSize
+	}
+	if len(root.Exclude) > 0 {
+		// In place of the defaults, so the names recorded with the previous
+		// manifest still take precedence
+		config.ExcludedFiles = root.Exclude
+	}
+	parity := cmd.Parity
+	if parity == 0 {
+		parity = root.Parity
+	}
+	if parity != 0 {
+		err = checkParityRedundancy(parity)
+		if err != nil {
+			return err
+		}
+	}
+	config.Checkpoint = true
+	config.Resume = cmd.Resume
 	manifestStorage := config.ManifestStorage()
+	if cmd.Resume && !manifestStorage.HasCheckpoint(path) {
+		cmd.logger.Printf("No checkpoint to resume for %s; starting from the beginning.\n", path)
+	}
+
+	latestManifest, err := manifestStorage.LatestManifestForPath(path)
+	if err != nil {
+		return err
+	}
+	config.useBaselineAlgorithm(latestManifest)
+	config.useBaselineChunkSize(latestManifest)
+	config.resolveExclusions(latestManifest, cmd.Exclude, cmd.AddExclude, cmd.RemoveExclude)
 
 	cmd.logger.Printf("Generating manifest for %s...\n", path)
 
@@ -80,18 +371,46 @@ func (cmd *Generate) Execute(args []string) (err error) {
 	if err != nil {
 		return err
 	}
+	if config.Quick {
+		cmd.logger.Print(quickSummary(manifest))
+	}
+	if config.Throttle != nil {
+		cmd.logger.Print(throttleSummary(config.Throttle))
+	}
+	if unstable := manifest.UnstableCount(); unstable > 0 {
+		cmd.logger.Printf("%d files kept changing while being hashed; recorded them as unstable.\n", unstable)
+	}
 
 	// Potentially validate manifest against previous
-	latestManifest, err := manifestStorage.LatestManifestForPath(path)
-	if err != nil {
-		return err
-	}
 	if latestManifest != nil {
 		ts := latestManifest.CreatedAt.Format(manifestNameTimeFormat)
 		cmd.logger.Printf("Comparing to previous manifest from %s\n", ts)
 		comparison := CompareManifests(latestManifest, manifest)
+		comparison.ConfirmFlagged(rereadCount(cmd.Rereads, root))
 		report := NewComparisonReport(comparison)
 		cmd.logger.Printf(report.ReportString())
+
+		err = manifestStorage.RecordIncidents(path, comparison, manifest.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if unreadable := len(comparison.UnreadablePaths); unreadable > 0 {
+			cmd.logger.Printf("Keeping previous checksums for %d files that could not be read.\n", unreadable)
+		}
+
+		if len(comparison.FlaggedPaths) > 0 {
+			if cmd.AcceptFlagged {
+				err = manifestStorage.ResolveIncidents(path, comparison.FlaggedPaths, resolutionAccepted, manifest.CreatedAt)
+				if err != nil {
+					return err
+				}
+				cmd.logger.Printf("Accepting new checksums for %d flagged files.\n", len(comparison.FlaggedPaths))
+			} else {
+				// Don't make possibly corrupted content the new baseline
+				manifest.KeepKnownGood(latestManifest, comparison.FlaggedPaths)
+				cmd.logger.Printf("Keeping last known-good checksums for %d flagged files; use --accept-flagged to record their current contents.\n", len(comparison.FlaggedPaths))
+			}
+		}
 	}
 
 	// Write new manifest
@@ -103,27 +422,77 @@ func (cmd *Generate) Execute(args []string) (err error) {
 
 	cmd.logger.Printf("Wrote manifest in %s\n", manifestStorage.Path)
 
+	if parity != 0 {
+		store, err := manifestStorage.ParityForPath(path)
+		if err != nil {
+			return err
+		}
+		update, err := UpdateParity(store, manifest, parity)
+		if err != nil {
+			return err
+		}
+		cmd.logger.Printf("Computed %d%% parity for %d files; removed parity for %d files.\n", parity, update.Generated, update.Removed)
+		if len(update.Stale) > 0 {
+			sort.Strings(update.Stale)
+			cmd.logger.Printf("No parity for %d files that changed while generating: %s\n", len(update.Stale), strings.Join(update.Stale, ", "))
+		}
+	}
+
+	err = manifestStorage.RemoveCheckpoint(path)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (cmd *Validate) Execute(args []string) (err error) {
-	config := DefaultConfig()
-	if len(cmd.Exclude) > 0 {
-		config.ExcludedFiles = cmd.Exclude
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
 	}
 	assertNoExtraArgs(&args, cmd.logger)
-	path, err := pathString(cmd.Arguments.Path)
+	if cmd.All {
+		return forEachRoot(config, cmd.logger, cmd.validate)
+	}
+	path, err := requiredPathString(cmd.Arguments.Path)
 	if err != nil {
 		return err
 	}
-	manifestStorage := config.ManifestStorage()
-
-	cmd.logger.Printf("Validating manifest for %s...\n", path)
+	return cmd.validate(path, config)
+}
 
-	currentManifest, err := NewManifest(path, config)
+func (cmd *Validate) validate(path string, config *Config) (err error) {
+	root := config.rootSettings(path)
+	config.HashAlgorithm = cmd.Hash
+	if config.HashAlgorithm == "" {
+		config.HashAlgorithm = root.Hash
+	}
+	config.Jobs = cmd.Jobs
+	if config.Jobs == 0 {
+		config.Jobs = root.Jobs
+	}
+	config.Quick = cmd.Quick || root.Quick
+	config.FollowSymlinks = cmd.FollowSymlinks || root.FollowSymlinks
+	config.CacheMode = cmd.BypassCache
+	if config.CacheMode == "" {
+		config.CacheMode = root.BypassCache
+	}
+	err = cmd.RunLimits.apply(config, root, cmd.logger)
 	if err != nil {
 		return err
 	}
+	if len(root.Exclude) > 0 {
+		// In place of the defaults, so the names recorded with the previous
+		// manifest still take precedence
+		config.ExcludedFiles = root.Exclude
+	}
+	config.Checkpoint = true
+	config.Resume = cmd.Resume
+	manifestStorage := config.ManifestStorage()
+	if cmd.Resume && !manifestStorage.HasCheckpoint(path) {
+		cmd.logger.Printf("No checkpoint to resume for %s; starting from the beginning.\n", path)
+	}
 
 	latestManifest, err := manifestStorage.LatestManifestForPath(path)
 	if err != nil {
@@ -134,14 +503,41 @@ func (cmd *Validate) Execute(args []string) (err error) {
 		cmd.logger.Printf("No previous manifest to validate for %s.", path)
 		return fmt.Errorf("")
 	}
+	config.useBaselineAlgorithm(latestManifest)
+	config.useBaselineChunkSize(latestManifest)
+	config.resolveExclusions(latestManifest, cmd.Exclude, cmd.AddExclude, cmd.RemoveExclude)
+
+	cmd.logger.Printf("Validating manifest for %s...\n", path)
+
+	currentManifest, err := NewManifest(path, config)
+	if err != nil {
+		return err
+	}
+	if config.Quick {
+		cmd.logger.Print(quickSummary(currentManifest))
+	}
+	if config.Throttle != nil {
+		cmd.logger.Print(throttleSummary(config.Throttle))
+	}
+	if unstable := currentManifest.UnstableCount(); unstable > 0 {
+		cmd.logger.Printf("%d files kept changing while being hashed; recorded them as unstable.\n", unstable)
+	}
+
+	err = manifestStorage.RemoveCheckpoint(path)
+	if err != nil {
+		return err
+	}
 
 	comparison := CompareManifests(latestManifest, currentManifest)
+	comparison.ConfirmFlagged(rereadCount(cmd.Rereads, root))
 	report := NewComparisonReport(comparison)
 	cmd.logger.Printf(report.ReportString())
+	err = manifestStorage.RecordIncidents(path, comparison, currentManifest.CreatedAt)
+	if err != nil {
+		return err
+	}
 
-	flagged := len(comparison.FlaggedPaths)
-	if flagged > 0 {
-		cmd.logger.Printf("%d files flagged for possible corruption.", flagged)
+	if logFailures(cmd.logger, comparison) {
 		return fmt.Errorf("")
 	} else {
 		cmd.logger.Printf("Validated manifest for %s.\n", path)
@@ -150,11 +546,509 @@ func (cmd *Validate) Execute(args []string) (err error) {
 	return nil
 }
 
+func (cmd *Scrub) Execute(args []string) (err error) {
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
+	}
+	assertNoExtraArgs(&args, cmd.logger)
+	path, err := pathString(cmd.Arguments.Path)
+	if err != nil {
+		return err
+	}
+	manifestStorage := config.ManifestStorage()
+
+	latestManifest, err := manifestStorage.LatestManifestForPath(path)
+	if err != nil {
+		return err
+	}
+	if latestManifest == nil {
+		cmd.logger.Printf("No previous manifest to scrub for %s.", path)
+		return fmt.Errorf("")
+	}
+
+	cycle := time.Duration(cmd.CycleDays) * 24 * time.Hour
+	budget := ScrubBudget{MaxDuration: cmd.MaxDuration, MaxBytes: cmd.MaxBytes}
+	if budget.MaxDuration == 0 && budget.MaxBytes == 0 {
+		budget.MaxBytes = ScrubBytesPerRun(latestManifest, cycle)
+	}
+
+	cacheMode := cmd.BypassCache
+	if cacheMode == "" {
+		cacheMode = config.rootSettings(path).BypassCache
+	}
+
+	cmd.logger.Printf("Scrubbing least recently verified files in %s...\n", path)
+
+	result, err := ScrubManifest(latestManifest, budget, cacheMode)
+	if err != nil {
+		return err
+	}
+	cmd.logger.Printf("Verified %d files (%s) in %s.\n", result.Files, result.Bytes, result.Duration.Round(time.Second))
+	result.Comparison.ConfirmFlagged(rereadCount(cmd.Rereads, config.rootSettings(path)))
+	report := NewComparisonReport(result.Comparison)
+	cmd.logger.Printf(report.ReportString())
+	err = manifestStorage.RecordIncidents(path, result.Comparison, result.Manifest.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = manifestStorage.AddManifest(result.Manifest)
+	if err != nil {
+		return err
+	}
+	if overdue := result.Overdue(cycle); overdue > 0 {
+		cmd.logger.Printf("%d files not verified in the last %d days.\n", overdue, cmd.CycleDays)
+	}
+
+	if logFailures(cmd.logger, result.Comparison) {
+		return fmt.Errorf("")
+	}
+
+	return nil
+}
+
+func (cmd *Accept) Execute(args []string) (err error) {
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
+	}
+	assertNoExtraArgs(&args, cmd.logger)
+	root, err := pathString(cmd.Arguments.Root)
+	if err != nil {
+		return err
+	}
+	relPaths := []string{}
+	for _, name := range cmd.Arguments.Paths {
+		path, err := pathString(name)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s is not a file under %s", path, root)
+		}
+		relPaths = append(relPaths, relPath)
+	}
+	manifestStorage := config.ManifestStorage()
+
+	latestManifest, err := manifestStorage.LatestManifestForPath(root)
+	if err != nil {
+		return err
+	}
+	if latestManifest == nil {
+		cmd.logger.Printf("No previous manifest for %s; use generate to create one.", root)
+		return fmt.Errorf("")
+	}
+
+	manifest, acceptance, err := AcceptFiles(latestManifest, relPaths)
+	if err != nil {
+		return err
+	}
+	acceptance.Note = cmd.Note
+
+	err = manifestStorage.AddManifest(manifest)
+	if err != nil {
+		return err
+	}
+	err = manifestStorage.AddAcceptance(root, acceptance)
+	if err != nil {
+		return err
+	}
+	acceptedPaths := []string{}
+	for _, file := range acceptance.Files {
+		acceptedPaths = append(acceptedPaths, file.Path)
+	}
+	err = manifestStorage.ResolveIncidents(root, acceptedPaths, resolutionAccepted, acceptance.AcceptedAt)
+	if err != nil {
+		return err
+	}
+
+	cmd.logger.Printf("Accepted current contents of %d files in %s:\n", len(acceptance.Files), root)
+	for _, file := range acceptance.Files {
+		cmd.logger.Printf("    %s\n", file.Path)
+	}
+	cmd.logger.Printf("Wrote manifest in %s\n", manifestStorage.Path)
+	return nil
+}
+
+func (cmd *Repair) Execute(args []string) (err error) {
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
+	}
+	assertNoExtraArgs(&args, cmd.logger)
+	path, err := pathString(cmd.Arguments.Path)
+	if err != nil {
+		return err
+	}
+	replica, err := pathString(cmd.From)
+	if err != nil {
+		return err
+	}
+	manifestStorage := config.ManifestStorage()
+
+	latestManifest, err := manifestStorage.LatestManifestForPath(path)
+	if err != nil {
+		return err
+	}
+	if latestManifest == nil {
+		cmd.logger.Printf("No previous manifest to repair %s from.", path)
+		return fmt.Errorf("")
+	}
+	cmd.logger.Printf("Checking %s for files to repair...\n", path)
+	comparison, err := compareToLatest(path, config, latestManifest, cmd.Jobs)
+	if err != nil {
+		return err
+	}
+
+	// Files found at several paths through symlinks are restored once
+	aliases := latestManifest.linkAliases()
+	results := []RepairResult{}
+	for _, relPath := range comparison.FlaggedPaths {
+		if _, ok := aliases[relPath]; !ok {
+			results = append(results, RepairResult{Path: relPath, Reason: repairReasonFlagged})
+		}
+	}
+	if cmd.Deleted {
+		for _, relPath := range comparison.DeletedPaths {
+			results = append(results, RepairResult{Path: relPath, Reason: repairReasonDeleted})
+		}
+	}
+	if len(results) == 0 {
+		cmd.logger.Printf("Nothing to repair in %s.\n", path)
+		return nil
+	}
+
+	restored := []string{}
+	for i := range results {
+		result := &results[i]
+		result.Err = RestoreFile(latestManifest, result.Path, replica, config.CacheMode, cmd.DryRun)
+		switch {
+		case result.Err != nil:
+			cmd.logger.Printf("Not restoring %s (%s): %s\n", result.Path, result.Reason, result.Err)
+		case cmd.DryRun:
+			cmd.logger.Printf("Would restore %s (%s)\n", result.Path, result.Reason)
+		default:
+			cmd.logger.Printf("Restored %s (%s)\n", result.Path, result.Reason)
+			restored = append(restored, result.Path)
+		}
+	}
+
+	if !cmd.DryRun {
+		resolved := append(restored, aliasesHandled(comparison.FlaggedPaths, restored, aliases)...)
+		err = manifestStorage.ResolveIncidents(path, resolved, resolutionRepaired, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := countFailedRepairs(results)
+	if cmd.DryRun {
+		cmd.logger.Printf("%d of %d files can be restored from %s.\n", len(results)-failed, len(results), replica)
+	} else {
+		cmd.logger.Printf("Restored %d of %d files from %s.\n", len(restored), len(results), replica)
+	}
+	if failed > 0 {
+		return fmt.Errorf("")
+	}
+	return nil
+}
+
+// Hashes a path with the algorithm and exclusions of its latest manifest, and
+// compares the result to it.
+func compareToLatest(path string, config *Config, latestManifest *Manifest, jobs int) (*ManifestComparison, error) {
+	config.Jobs = jobs
+	if config.Jobs == 0 {
+		config.Jobs = config.rootSettings(path).Jobs
+	}
+	config.CacheMode = config.rootSettings(path).BypassCache
+	// Paths found through symlinks are only there to compare if they're
+	// followed as they were for the manifest
+	config.FollowSymlinks = config.rootSettings(path).FollowSymlinks || latestManifest.followedSymlinks()
+	config.useBaselineAlgorithm(latestManifest)
+	config.useBaselineChunkSize(latestManifest)
+	config.resolveExclusions(latestManifest, nil, nil, nil)
+
+	currentManifest, err := NewManifest(path, config)
+	if err != nil {
+		return nil, err
+	}
+	return CompareManifests(latestManifest, currentManifest), nil
+}
+
+func (cmd *Heal) Execute(args []string) (err error) {
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
+	}
+	assertNoExtraArgs(&args, cmd.logger)
+	path, err := pathString(cmd.Arguments.Path)
+	if err != nil {
+		return err
+	}
+	manifestStorage := config.ManifestStorage()
+
+	latestManifest, err := manifestStorage.LatestManifestForPath(path)
+	if err != nil {
+		return err
+	}
+	if latestManifest == nil {
+		cmd.logger.Printf("No previous manifest to heal %s from.", path)
+		return fmt.Errorf("")
+	}
+	store, err := manifestStorage.ParityForPath(path)
+	if err != nil {
+		return err
+	}
+
+	cmd.logger.Printf("Checking %s for files to heal...\n", path)
+	comparison, err := compareToLatest(path, config, latestManifest, cmd.Jobs)
+	if err != nil {
+		return err
+	}
+	if len(comparison.FlaggedPaths) == 0 {
+		cmd.logger.Printf("Nothing to heal in %s.\n", path)
+		return nil
+	}
+
+	// Files found at several paths through symlinks are healed once
+	aliases := latestManifest.linkAliases()
+	healed := []string{}
+	for _, relPath := range comparison.FlaggedPaths {
+		if _, ok := aliases[relPath]; ok {
+			continue
+		}
+		err = store.Heal(latestManifest, relPath, cmd.DryRun)
+		switch {
+		case err != nil:
+			cmd.logger.Printf("Not healing %s: %s\n", relPath, err)
+		case cmd.DryRun:
+			cmd.logger.Printf("Would heal %s\n", relPath)
+			healed = append(healed, relPath)
+		default:
+			cmd.logger.Printf("Healed %s\n", relPath)
+			healed = append(healed, relPath)
+		}
+	}
+
+	healed = append(healed, aliasesHandled(comparison.FlaggedPaths, healed, aliases)...)
+	total := len(comparison.FlaggedPaths)
+	if cmd.DryRun {
+		cmd.logger.Printf("%d of %d flagged files can be healed from parity.\n", len(healed), total)
+	} else {
+		err = manifestStorage.ResolveIncidents(path, healed, resolutionRepaired, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		cmd.logger.Printf("Healed %d of %d flagged files from parity.\n", len(healed), total)
+	}
+	if len(healed) < total {
+		return fmt.Errorf("")
+	}
+	return nil
+}
+
+func countFailedRepairs(results []RepairResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (cmd *Incidents) Execute(args []string) (err error) {
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
+	}
+	assertNoExtraArgs(&args, cmd.logger)
+	manifestStorage := config.ManifestStorage()
+
+	roots := []string{}
+	if cmd.Arguments.Path != "" {
+		root, err := pathString(cmd.Arguments.Path)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, root)
+	} else {
+		entries, err := manifestStorage.List()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			roots = append(roots, entry.Path)
+		}
+		sort.Strings(roots)
+	}
+
+	listed := 0
+	for _, root := range roots {
+		incidents, err := manifestStorage.IncidentsForPath(root)
+		if err != nil {
+			return err
+		}
+		if cmd.Open {
+			open := []*Incident{}
+			for _, incident := range incidents {
+				if incident.Open() {
+					open = append(open, incident)
+				}
+			}
+			incidents = open
+		}
+		if len(incidents) == 0 {
+			continue
+		}
+		sortIncidents(incidents)
+		cmd.logger.Printf("Incidents for %s:\n", root)
+		for _, incident := range incidents {
+			cmd.logger.Printf("    %s\n", incident)
+		}
+		listed += len(incidents)
+	}
+	if listed == 0 {
+		cmd.logger.Println("No incidents.")
+	}
+	return nil
+}
+
+func (cmd *CheckIgnore) Execute(args []string) (err error) {
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
+	}
+	assertNoExtraArgs(&args, cmd.logger)
+	path, err := pathString(cmd.Arguments.Path)
+	if err != nil {
+		return err
+	}
+	manifestStorage := config.ManifestStorage()
+
+	var root string
+	if cmd.Root != "" {
+		root, err = pathString(cmd.Root)
+	} else {
+		root, err = trackedRootContaining(manifestStorage, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	latestManifest, err := manifestStorage.LatestManifestForPath(root)
+	if err != nil {
+		return err
+	}
+	config.resolveExclusions(latestManifest, nil, nil, nil)
+
+	explanation, err := explainIgnore(root, path, config)
+	if err != nil {
+		return err
+	}
+	cmd.logger.Println(explanation)
+	return nil
+}
+
+// Finds the most specific path with stored manifests that contains path
+func trackedRootContaining(manifestStorage *ManifestStorage, path string) (string, error) {
+	entries, err := manifestStorage.List()
+	if err != nil {
+		return "", err
+	}
+	root := ""
+	for _, entry := range entries {
+		if path == entry.Path || strings.HasPrefix(path, entry.Path+string(filepath.Separator)) {
+			if len(entry.Path) > len(root) {
+				root = entry.Path
+			}
+		}
+	}
+	if root == "" {
+		return "", fmt.Errorf("no tracked directory contains %s; use --root to specify one", path)
+	}
+	return root, nil
+}
+
+func (cmd *Vote) Execute(args []string) (err error) {
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
+	}
+	if len(cmd.Exclude) > 0 {
+		config.ExcludedFiles = cmd.Exclude
+	}
+	config.HashAlgorithm = cmd.Hash
+	config.Jobs = cmd.Jobs
+	assertNoExtraArgs(&args, cmd.logger)
+	manifestStorage := config.ManifestStorage()
+
+	paths := []string{}
+	stored := []*Manifest{}
+	for _, name := range cmd.Arguments.Paths {
+		path, err := pathString(name)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		manifest, err := manifestStorage.LatestManifestForPath(path)
+		if err != nil {
+			return err
+		}
+		stored = append(stored, manifest)
+	}
+	if config.HashAlgorithm == "" {
+		for _, manifest := range stored {
+			if manifest != nil {
+				config.HashAlgorithm = manifest.HashAlgorithm()
+				break
+			}
+		}
+	}
+
+	replicas := []*Manifest{}
+	for _, path := range paths {
+		cmd.logger.Printf("Hashing %s...\n", path)
+		manifest, err := NewManifest(path, config)
+		if err != nil {
+			return err
+		}
+		replicas = append(replicas, manifest)
+	}
+
+	votes := VoteOnReplicas(replicas, stored)
+	cmd.logger.Printf("\n%s", VoteReport(votes))
+	if cmd.Plan {
+		plan := VoteRepairPlan(votes)
+		cmd.logger.Printf("\nRepair plan: %d copies\n", len(plan))
+		for _, step := range plan {
+			cmd.logger.Printf("    %s\n", step)
+		}
+	}
+
+	for _, vote := range votes {
+		if len(vote.Corrupt) > 0 {
+			return fmt.Errorf("")
+		}
+	}
+	return nil
+}
+
 func (cmd *Compare) Execute(args []string) (err error) {
-	config := DefaultConfig()
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
+	}
 	if len(cmd.Exclude) > 0 {
 		config.ExcludedFiles = cmd.Exclude
 	}
+	config.HashAlgorithm = cmd.Hash
+	config.Jobs = cmd.Jobs
 	assertNoExtraArgs(&args, cmd.logger)
 	oldPath, err := pathString(cmd.Arguments.Old)
 	if err != nil {
@@ -180,9 +1074,7 @@ func (cmd *Compare) Execute(args []string) (err error) {
 	report := NewComparisonReport(comparison)
 	cmd.logger.Printf(report.ReportString())
 
-	flagged := len(comparison.FlaggedPaths)
-	if flagged > 0 {
-		cmd.logger.Printf("%d files flagged for possible corruption.", flagged)
+	if logFailures(cmd.logger, comparison) {
 		return fmt.Errorf("")
 	} else {
 		cmd.logger.Printf("Successfully validated %s as a copy of %s.\n", newPath, oldPath)
@@ -192,7 +1084,10 @@ func (cmd *Compare) Execute(args []string) (err error) {
 }
 
 func (cmd *CompareLatestManifests) Execute(args []string) (err error) {
-	config := DefaultConfig()
+	config, err := cmd.options.loadConfig()
+	if err != nil {
+		return err
+	}
 	if len(cmd.Exclude) > 0 {
 		config.ExcludedFiles = cmd.Exclude
 	}
@@ -227,14 +1122,19 @@ func (cmd *CompareLatestManifests) Execute(args []string) (err error) {
 		cmd.logger.Printf("No existing manifest for %s\n", newPath)
 		return nil
 	}
+	if !ComparableManifests(oldManifest, newManifest) {
+		return fmt.Errorf(
+			"manifests use different hash algorithms (%s and %s); regenerate one with --hash",
+			oldManifest.HashAlgorithm(),
+			newManifest.HashAlgorithm(),
+		)
+	}
 
 	comparison := CompareManifests(oldManifest, newManifest)
 	report := NewComparisonReport(comparison)
 	cmd.logger.Printf(report.ReportString())
 
-	flagged := len(comparison.FlaggedPaths)
-	if flagged > 0 {
-		cmd.logger.Printf("%d files flagged for possible corruption.", flagged)
+	if logFailures(cmd.logger, comparison) {
 		return fmt.Errorf("")
 	} else {
 		cmd.logger.Printf("Successfully validated %s as a copy of %s.\n", newPath, oldPath)
@@ -243,12 +1143,67 @@ func (cmd *CompareLatestManifests) Execute(args []string) (err error) {
 	return nil
 }
 
+// Runs a command for each configured root, summarizing the results
+func forEachRoot(config *Config, logger *log.Logger, run func(path string, config *Config) error) error {
+	names := config.RootNames()
+	if len(names) == 0 {
+		return fmt.Errorf("no roots are declared in the config file")
+	}
+
+	summary := ""
+	failed := 0
+	for _, name := range names {
+		root := config.Roots[name]
+		logger.Printf("==> %s (%s)\n", name, root.Path)
+		// Each root gets settings of its own
+		rootConfig := *config
+		err := run(root.Path, &rootConfig)
+		status := "OK"
+		if err != nil {
+			failed++
+			status = "FAILED"
+			if err.Error() != "" {
+				status += ": " + err.Error()
+			}
+		}
+		summary += fmt.Sprintf("    %s (%s): %s\n", name, root.Path, status)
+		logger.Println()
+	}
+
+	logger.Printf("Summary for %d roots:\n%s", len(names), summary)
+	if failed > 0 {
+		logger.Printf("%d of %d roots failed.\n", failed, len(names))
+		return fmt.Errorf("")
+	}
+	return nil
+}
+
+func quickSummary(manifest *Manifest) string {
+	carried := manifest.CarriedForwardCount()
+	return fmt.Sprintf("Re-hashed %d files; carried forward %d unchanged files.\n", len(manifest.Entries)-carried, carried)
+}
+
 func assertNoExtraArgs(args *[]string, logger *log.Logger) {
 	if len(*args) > 0 {
 		logger.Fatalf("Unrecognized arguments: %s\n", strings.Join(*args, " "))
 	}
 }
 
+// GlobalOptions are options given before the command.
+type GlobalOptions struct {
+	Version    func() `long:"version" short:"v"`
+	ConfigFile string `long:"config" value-name:"FILE" description:"Config file to use instead of config.yaml in the config directory ($BITROT_DIR or ~/.bitrot)."`
+}
+
+// loadConfig loads the config file given with --config, or the default one if
+// there are no global options (as for commands made in tests).
+func (options *GlobalOptions) loadConfig() (*Config, error) {
+	if options == nil {
+		return LoadConfig("")
+	}
+	return LoadConfig(options.ConfigFile)
+}
+
 func addCommand(parser *flags.Parser, name, summary, description string, command interface{}) {
 	_, err := parser.AddCommand(name, summary, description, command)
 	if err != nil {
@@ -258,41 +1213,88 @@ func addCommand(parser *flags.Parser, name, summary, description string, command
 
 func main() {
 	logger := log.New(os.Stdout, "", 0)
-	var AppOpts struct {
-		Version func() `long:"version" short:"v"`
-	}
-	AppOpts.Version = func() {
+	options := &GlobalOptions{}
+	options.Version = func() {
 		logger.Printf("%s version %s\n", name, version)
 		os.Exit(0)
 	}
-	parser := flags.NewParser(&AppOpts, flags.HelpFlag|flags.PassDoubleDash)
+	parser := flags.NewParser(options, flags.HelpFlag|flags.PassDoubleDash)
 	addCommand(
 		parser,
 		"generate",
 		"Generate manifest",
 		"Generate manifest for directory",
-		&Generate{logger: logger},
+		&Generate{logger: logger, options: options},
 	)
 	addCommand(
 		parser,
 		"validate",
 		"Validate manifest",
 		"Validate manifest for directory",
-		&Validate{logger: logger},
+		&Validate{logger: logger, options: options},
+	)
+	addCommand(
+		parser,
+		"scrub",
+		"Verify least recently verified files",
+		"Verify the least recently verified files in a manifest, within a time or size budget",
+		&Scrub{logger: logger, options: options},
+	)
+	addCommand(
+		parser,
+		"accept",
+		"Accept changes to files",
+		"Re-hash files whose changes are intended, such as flagged files, and record them in the latest manifest without accepting any other changes",
+		&Accept{logger: logger, options: options},
+	)
+	addCommand(
+		parser,
+		"repair",
+		"Restore files from a replica",
+		"Restore flagged (and optionally deleted) files from a replica whose copies match the last known-good checksums",
+		&Repair{logger: logger, options: options},
+	)
+	addCommand(
+		parser,
+		"heal",
+		"Heal flagged files from parity",
+		"Reconstruct flagged files from the parity stored by generate --parity, when the damage is within what the parity can repair",
+		&Heal{logger: logger, options: options},
+	)
+	addCommand(
+		parser,
+		"incidents",
+		"List flagged file incidents",
+		"List files flagged for possible corruption, when they were flagged and how they were resolved, for one or all tracked directories",
+		&Incidents{logger: logger, options: options},
+	)
+	addCommand(
+		parser,
+		"check-ignore",
+		"Explain whether a path is ignored",
+		"Show which exclusion or ignore rule, if any, applies to a path",
+		&CheckIgnore{logger: logger, options: options},
 	)
 	addCommand(
 		parser,
 		"compare",
 		"Compare manifests",
 		"Compare manifests for two directories",
-		&Compare{logger: logger},
+		&Compare{logger: logger, options: options},
+	)
+	addCommand(
+		parser,
+		"vote",
+		"Find corrupt copies by majority vote",
+		"Compare three or more replicas of a directory, judging the content most copies share to be correct (using stored manifests to break ties)",
+		&Vote{logger: logger, options: options},
 	)
 	addCommand(
 		parser,
 		"compare-latest-manifests",
 		"Compare latest manifests",
 		"Compare latest manifests for two directories",
-		&CompareLatestManifests{logger: logger},
+		&CompareLatestManifests{logger: logger, options: options},
 	)
 	_, err := parser.Parse()
 	if err != nil {
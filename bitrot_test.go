@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -172,6 +174,45 @@ func (suite *CommandsIntegrationTestSuite) TestGenerateCommand() {
 	suite.LogContains(fmt.Sprintf("Wrote manifest"))
 }
 
+func (suite *CommandsIntegrationTestSuite) TestGenerateCommandWithConfigFile() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	configFile := filepath.Join(suite.tempDir, "other.yaml")
+	assert.Nil(suite.T(), ioutil.WriteFile(configFile, []byte("roots:\n  here:\n    path: "+suite.tempDir+"\n    hash: sha256\n"), 0644))
+	cmd := suite.generateCommand(suite.tempDir)
+	cmd.options = &GlobalOptions{ConfigFile: configFile}
+	err := cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	latest, err := DefaultConfig().ManifestStorage().LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "sha256", latest.HashAlgorithm())
+}
+
+func (suite *CommandsIntegrationTestSuite) TestConfiguredRootKeepsExclusionChanges() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	suite.writeTestFile("cache/baz", "cached")
+	suite.writeTestFile("tmp/qux", "temporary")
+	configFile := filepath.Join(suite.homeDir, "other.yaml")
+	assert.Nil(suite.T(), ioutil.WriteFile(configFile, []byte("roots:\n  here:\n    path: "+suite.tempDir+"\n    exclude: [cache]\n"), 0644))
+	generate := func(add []string) *Manifest {
+		cmd := suite.generateCommand(suite.tempDir)
+		cmd.options = &GlobalOptions{ConfigFile: configFile}
+		cmd.AddExclude = add
+		assert.Nil(suite.T(), cmd.Execute([]string{}))
+		latest, err := DefaultConfig().ManifestStorage().LatestManifestForPath(suite.tempDir)
+		assert.Nil(suite.T(), err)
+		return latest
+	}
+
+	assert.Equal(suite.T(), []string{"cache"}, generate(nil).Excludes)
+	assert.Equal(suite.T(), []string{"cache", "tmp"}, generate([]string{"tmp"}).Excludes)
+	// The configured excludes don't undo the addition
+	latest := generate(nil)
+	assert.Equal(suite.T(), []string{"cache", "tmp"}, latest.Excludes)
+	assert.Len(suite.T(), latest.Entries, 1)
+	assert.Contains(suite.T(), latest.Entries, filepath.Join("foo", "bar"))
+}
+
 func (suite *CommandsIntegrationTestSuite) TestGenerateCommandWithExistingManifest() {
 	suite.writeTestFile("foo/bar", helloWorldString)
 	err := suite.generateCommand(suite.tempDir).Execute([]string{})
@@ -221,6 +262,145 @@ func (suite *CommandsIntegrationTestSuite) TestGenerateCommandWithExistingManife
 	suite.LogContains("Flagged paths: 1\n    foo/flagged")
 }
 
+func (suite *CommandsIntegrationTestSuite) TestGenerateCommandKeepsKnownGoodChecksums() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	original, err := DefaultConfig().ManifestStorage().LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+
+	suite.corruptTestFile("foo/bar")
+	suite.clearLog()
+	err = suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Keeping last known-good checksums for 1 flagged files")
+
+	manifest, err := DefaultConfig().ManifestStorage().LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	entry := manifest.Entries["foo/bar"]
+	assert.Equal(suite.T(), original.Entries["foo/bar"].Checksum, entry.Checksum)
+	if assert.NotNil(suite.T(), entry.FlaggedSince) {
+		assert.Equal(suite.T(), manifest.CreatedAt, *entry.FlaggedSince)
+	}
+	flaggedSince := *entry.FlaggedSince
+
+	// Still flagged by later runs, which keep the original flag time
+	suite.clearLog()
+	cmd := suite.generateCommand(suite.tempDir)
+	cmd.Quick = true
+	err = cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Flagged paths: 1\n    foo/bar (persistently different; flagged since ")
+	manifest, err = DefaultConfig().ManifestStorage().LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), flaggedSince, *manifest.Entries["foo/bar"].FlaggedSince)
+
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains("Flagged paths: 1\n    foo/bar (persistently different; flagged since ")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestGenerateCommandAcceptFlagged() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.corruptTestFile("foo/bar")
+	suite.clearLog()
+	cmd := suite.generateCommand(suite.tempDir)
+	cmd.AcceptFlagged = true
+	err = cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Accepting new checksums for 1 flagged files.")
+
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Unchanged paths: 1\n")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestGenerateCommandMigratesHashAlgorithm() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.corruptTestFile("foo/bar")
+	suite.clearLog()
+	cmd := suite.generateCommand(suite.tempDir)
+	cmd.Hash = "sha256"
+	cmd.AcceptFlagged = true
+	err = cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Flagged paths: 1\n    foo/bar")
+
+	manifest, err := DefaultConfig().ManifestStorage().LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "sha256", manifest.Algorithm)
+	assert.Equal(suite.T(), []string{"sha1"}, manifest.AltAlgorithms)
+
+	// Subsequent runs keep the new algorithm
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Unchanged paths: 1\n")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestFlaggedDuringHashMigration() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.corruptTestFile("foo/bar")
+	cmd := suite.generateCommand(suite.tempDir)
+	cmd.Hash = "sha256"
+	err = cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	// Still checked against the old algorithm's checksum until resolved
+	err = suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	storage := DefaultConfig().ManifestStorage()
+	manifest, err := storage.LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), []string{"sha1"}, manifest.AltAlgorithms)
+	algorithm, checksum, ok := knownGoodChecksum(manifest, "foo/bar")
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), "sha1", algorithm)
+	assert.Equal(suite.T(), helloWorldChecksum, checksum)
+
+	// Once restored, the file verifies and the migration can finish
+	suite.corruptTestFile("foo/bar")
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Unchanged paths: 1\n")
+	err = suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	err = suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	manifest, err = storage.LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	assert.Empty(suite.T(), manifest.AltAlgorithms)
+	assert.Nil(suite.T(), manifest.Entries["foo/bar"].FlaggedSince)
+}
+
+func (suite *CommandsIntegrationTestSuite) TestGenerateCommandQuick() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.writeTestFile("foo/added", "added")
+	suite.clearLog()
+	cmd := suite.generateCommand(suite.tempDir)
+	cmd.Quick = true
+	err = cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.LogContains("Re-hashed 1 files; carried forward 1 unchanged files.")
+	suite.LogContains("Added paths: 1\n    foo/added")
+}
+
 func (suite *CommandsIntegrationTestSuite) TestValidateCommand() {
 	suite.writeTestFile("foo/bar", helloWorldString)
 
@@ -244,7 +424,201 @@ func (suite *CommandsIntegrationTestSuite) TestValidateCommandFailure() {
 	err = suite.validateCommand().Execute([]string{})
 	assert.NotNil(suite.T(), err)
 
-	suite.LogContains("Flagged paths: 1\n    foo/bar\n")
+	suite.LogContains("Flagged paths: 1\n    foo/bar (persistently different)\n")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestUnreadableFiles() {
+	if os.Geteuid() == 0 {
+		suite.T().Skip("permissions don't apply to root")
+	}
+	suite.writeTestFile("foo/bar", helloWorldString)
+	suite.writeTestFile("foo/secret", helloWorldString)
+	suite.writeTestFile("locked/file", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	secret := filepath.Join(suite.tempDir, "foo", "secret")
+	locked := filepath.Join(suite.tempDir, "locked")
+	assert.Nil(suite.T(), os.Chmod(secret, 0))
+	assert.Nil(suite.T(), os.Chmod(locked, 0))
+	defer os.Chmod(secret, 0644)
+	defer os.Chmod(locked, 0755)
+
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains("Unchanged paths: 1\n")
+	suite.LogContains("Unreadable paths: 2 (26 B)\n")
+	suite.LogContains("    foo/secret (open: permission denied)\n")
+	suite.LogContains("    locked/file (open: permission denied)\n")
+	suite.LogContains("2 files could not be read.\n")
+
+	// The previous checksums are kept
+	err = suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Keeping previous checksums for 2 files that could not be read.\n")
+	manifest, err := DefaultConfig().ManifestStorage().LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	for _, relPath := range []string{"foo/secret", "locked/file"} {
+		assert.Equal(suite.T(), helloWorldChecksum, manifest.Entries[relPath].Checksum)
+		assert.Equal(suite.T(), "open: permission denied", manifest.Entries[relPath].Unreadable)
+	}
+
+	assert.Nil(suite.T(), os.Chmod(secret, 0644))
+	assert.Nil(suite.T(), os.Chmod(locked, 0755))
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Unchanged paths: 3\n")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestValidateCommandWithLimits() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	suite.writeTestFile("foo/baz", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.clearLog()
+	validate := suite.validateCommand()
+	validate.MaxReadRate = 10
+	validate.MaxFilesPerSecond = 100
+	validate.FileTimeout = time.Minute
+	if runtime.GOOS == "linux" {
+		validate.IOPriority = ioPriorityBestEffort
+	}
+	err = validate.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	if runtime.GOOS == "linux" {
+		suite.LogContains("Limits: reading at most 10.0 MiB/s, 100 files/s, giving up on files stalled for 1m0s, best-effort I/O priority.\n")
+	} else {
+		suite.LogContains("Limits: reading at most 10.0 MiB/s, 100 files/s, giving up on files stalled for 1m0s.\n")
+	}
+	suite.LogContains(" for read limits (10.0 MiB/s, 100 files/s).\n")
+	suite.LogContains("Unchanged paths: 2\n")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestRunLimitsPriorityIsSetOnce() {
+	limits := &RunLimits{}
+	assert.Nil(suite.T(), limits.apply(&Config{}, RootConfig{}, suite.logger))
+	assert.Nil(suite.T(), limits.apply(&Config{}, RootConfig{MaxReadRate: 10}, suite.logger))
+	// Priority is per process, so a later root can't change it
+	assert.NotNil(suite.T(), limits.apply(&Config{}, RootConfig{Nice: 10}, suite.logger))
+}
+
+func (suite *CommandsIntegrationTestSuite) TestValidateCommandReportsSymlinks() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	suite.writeTestFile("foo/baz", helloWorldString)
+	assert.Nil(suite.T(), os.Symlink("foo/bar", filepath.Join(suite.tempDir, "link")))
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	assert.Nil(suite.T(), os.Remove(filepath.Join(suite.tempDir, "link")))
+	assert.Nil(suite.T(), os.Symlink("foo/baz", filepath.Join(suite.tempDir, "link")))
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Retargeted link paths: 1\n    link -> foo/baz (was foo/bar)\n")
+
+	// Following the link checks what it points to
+	suite.clearLog()
+	validate := suite.validateCommand()
+	validate.FollowSymlinks = true
+	err = validate.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Unchanged paths: 2\n")
+	suite.LogContains("Retargeted link paths: 1\n")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestValidateCommandReportsChangedRanges() {
+	suite.writeTestFile("foo/bar", strings.Repeat(helloWorldString, 1000))
+	cmd := suite.generateCommand(suite.tempDir)
+	cmd.ChunkSize = 1024
+	err := cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.corruptTestFile("foo/bar")
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains("Flagged paths: 1\n    foo/bar (persistently different; overwritten, low confidence; 1.0 KiB changed in bytes 0-1023)\n")
+
+	// Later manifests keep the chunk size
+	err = suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	manifest, err := DefaultConfig().ManifestStorage().LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int64(1024), manifest.ChunkSize)
+	assert.Len(suite.T(), manifest.Entries["foo/bar"].Chunks, 13)
+}
+
+func (suite *CommandsIntegrationTestSuite) TestValidateCommandMetadataChanged() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	assert.Nil(suite.T(), os.Chmod(filepath.Join(suite.tempDir, "foo/bar"), 0600))
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.LogContains("Metadata changed paths: 1 (13 B)\n")
+	suite.LogContains("Metadata changed paths: 1\n    foo/bar (mode -rw-r--r-- -> -rw-------)\n")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestValidateCommandReusesExclusions() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	suite.writeTestFile("cache/baz", "cached")
+	cmd := suite.generateCommand(suite.tempDir)
+	cmd.Exclude = []string{"cache"}
+	err := cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Unchanged paths: 1\n")
+	suite.LogContains("Added paths: 0\n")
+
+	// Changing exclusions is reported
+	suite.clearLog()
+	cmd = suite.generateCommand(suite.tempDir)
+	cmd.RemoveExclude = []string{"cache"}
+	cmd.AddExclude = []string{"foo"}
+	err = cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("WARNING: manifests were generated with different exclusions")
+	suite.LogContains("Newly excluded: \"foo\"\nNo longer excluded: \"cache\"\n")
+	suite.LogContains("Added paths: 1\n    cache/baz")
+
+	manifest, err := DefaultConfig().ManifestStorage().LatestManifestForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), []string{"foo"}, manifest.Excludes)
+}
+
+func (suite *CommandsIntegrationTestSuite) TestValidateAllConfiguredRoots() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	otherDir := suite.copyTempDir()
+	defer os.RemoveAll(otherDir)
+	configFile := fmt.Sprintf("roots:\n  first:\n    path: %s\n  second:\n    path: %s\n    hash: sha256\n", suite.tempDir, otherDir)
+	assert.Nil(suite.T(), os.MkdirAll(filepath.Join(suite.homeDir, configDir), 0755))
+	assert.Nil(suite.T(), ioutil.WriteFile(filepath.Join(suite.homeDir, configDir, configFileName), []byte(configFile), 0644))
+
+	generate := &Generate{All: true, logger: suite.logger}
+	err := generate.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains(fmt.Sprintf("Summary for 2 roots:\n    first (%s): OK\n    second (%s): OK\n", suite.tempDir, otherDir))
+
+	manifest, err := DefaultConfig().ManifestStorage().LatestManifestForPath(otherDir)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "sha256", manifest.Algorithm)
+
+	suite.corruptTestFile("foo/bar")
+	suite.clearLog()
+	validate := &Validate{All: true, logger: suite.logger}
+	err = validate.Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains(fmt.Sprintf("    first (%s): FAILED\n    second (%s): OK\n", suite.tempDir, otherDir))
+	suite.LogContains("1 of 2 roots failed.")
 }
 
 func (suite *CommandsIntegrationTestSuite) TestValidateWithNoManifests() {
@@ -255,6 +629,393 @@ func (suite *CommandsIntegrationTestSuite) TestValidateWithNoManifests() {
 	suite.LogContains(fmt.Sprintf("No previous manifest to validate for %s.", suite.tempDir))
 }
 
+func (suite *CommandsIntegrationTestSuite) TestScrubCommand() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.corruptTestFile("foo/bar")
+	suite.clearLog()
+	cmd := &Scrub{
+		CycleDays: 30,
+		Arguments: PathArguments{Path: flags.Filename(suite.tempDir)},
+		logger:    suite.logger,
+	}
+	err = cmd.Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains("Verified 1 files (13 B)")
+	suite.LogContains("Flagged paths: 1\n    foo/bar (persistently different)\n")
+
+	// Corruption is still flagged on the next run
+	suite.clearLog()
+	err = cmd.Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains("Flagged paths: 1\n    foo/bar (persistently different; flagged since ")
+
+	// Re-reads can be skipped
+	suite.clearLog()
+	cmd.Rereads = -1
+	err = cmd.Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains("Flagged paths: 1\n    foo/bar (flagged since ")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestScrubWithNoManifests() {
+	cmd := &Scrub{
+		Arguments: PathArguments{Path: flags.Filename(suite.tempDir)},
+		logger:    suite.logger,
+	}
+	err := cmd.Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains(fmt.Sprintf("No previous manifest to scrub for %s.", suite.tempDir))
+}
+
+func (suite *CommandsIntegrationTestSuite) TestAcceptCommand() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	suite.writeTestFile("foo/baz", "another file")
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.corruptTestFile("foo/bar")
+	suite.corruptTestFile("foo/baz")
+	suite.clearLog()
+	cmd := &Accept{
+		Note: "edited in place",
+		Arguments: AcceptArguments{
+			Root:  flags.Filename(suite.tempDir),
+			Paths: []flags.Filename{flags.Filename(filepath.Join(suite.tempDir, "foo/bar"))},
+		},
+		logger: suite.logger,
+	}
+	err = cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Accepted current contents of 1 files")
+
+	// Only the accepted file is no longer flagged
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains("Unchanged paths: 1\n")
+	suite.LogContains("Flagged paths: 1\n    foo/baz (persistently different)\n")
+
+	acceptances, err := DefaultConfig().ManifestStorage().AcceptancesForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), acceptances, 1) {
+		assert.Equal(suite.T(), "edited in place", acceptances[0].Note)
+		assert.NotEmpty(suite.T(), acceptances[0].User)
+		if assert.Len(suite.T(), acceptances[0].Files, 1) {
+			assert.Equal(suite.T(), filepath.Join("foo", "bar"), acceptances[0].Files[0].Path)
+			assert.NotEqual(suite.T(), acceptances[0].Files[0].OldChecksum, acceptances[0].Files[0].NewChecksum)
+		}
+	}
+}
+
+func (suite *CommandsIntegrationTestSuite) TestAcceptCommandOutsideRoot() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	cmd := &Accept{
+		Arguments: AcceptArguments{
+			Root:  flags.Filename(filepath.Join(suite.tempDir, "foo")),
+			Paths: []flags.Filename{flags.Filename(suite.tempDir)},
+		},
+		logger: suite.logger,
+	}
+	assert.NotNil(suite.T(), cmd.Execute([]string{}))
+}
+
+func (suite *CommandsIntegrationTestSuite) TestAcceptCommandUntrackedPath() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.writeTestFile("foo/new", "not in the manifest")
+	cmd := &Accept{
+		Arguments: AcceptArguments{
+			Root:  flags.Filename(suite.tempDir),
+			Paths: []flags.Filename{flags.Filename(filepath.Join(suite.tempDir, "foo/new"))},
+		},
+		logger: suite.logger,
+	}
+	err = cmd.Execute([]string{})
+	if assert.NotNil(suite.T(), err) {
+		assert.Contains(suite.T(), err.Error(), "is not in the manifest")
+	}
+
+	acceptances, err := DefaultConfig().ManifestStorage().AcceptancesForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	assert.Empty(suite.T(), acceptances)
+}
+
+func (suite *CommandsIntegrationTestSuite) TestAcceptCommandUnfollowedSymlink() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	assert.Nil(suite.T(), os.Symlink("foo/bar", filepath.Join(suite.tempDir, "link")))
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	cmd := &Accept{
+		Arguments: AcceptArguments{
+			Root:  flags.Filename(suite.tempDir),
+			Paths: []flags.Filename{flags.Filename(filepath.Join(suite.tempDir, "link"))},
+		},
+		logger: suite.logger,
+	}
+	err = cmd.Execute([]string{})
+	if assert.NotNil(suite.T(), err) {
+		assert.Contains(suite.T(), err.Error(), "is a symlink")
+	}
+
+	acceptances, err := DefaultConfig().ManifestStorage().AcceptancesForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	assert.Empty(suite.T(), acceptances)
+
+	// The link is still recorded as a link
+	suite.clearLog()
+	err = suite.validateCommand().Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Unchanged paths: 2\n")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestRepairCommand() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	suite.writeTestFile("foo/baz", "another file")
+	suite.writeTestFile("foo/deleted", "deleted file")
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	replica := suite.copyTempDir()
+	defer os.RemoveAll(replica)
+
+	suite.corruptTestFile("foo/bar")
+	suite.deleteTestFile("foo/deleted")
+	cmd := &Repair{
+		From:      flags.Filename(replica),
+		Deleted:   true,
+		DryRun:    true,
+		Arguments: PathArguments{Path: flags.Filename(suite.tempDir)},
+		logger:    suite.logger,
+	}
+	suite.clearLog()
+	assert.Nil(suite.T(), cmd.Execute([]string{}))
+	suite.LogContains("Would restore foo/bar (flagged)")
+	suite.LogContains("Would restore foo/deleted (deleted)")
+	suite.LogContains("2 of 2 files can be restored")
+	assert.NotNil(suite.T(), suite.validateCommand().Execute([]string{}))
+
+	suite.clearLog()
+	cmd.DryRun = false
+	assert.Nil(suite.T(), cmd.Execute([]string{}))
+	suite.LogContains("Restored 2 of 2 files")
+
+	suite.clearLog()
+	assert.Nil(suite.T(), suite.validateCommand().Execute([]string{}))
+	suite.LogContains("Unchanged paths: 3\n")
+	incidents, err := DefaultConfig().ManifestStorage().IncidentsForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), incidents, 1) {
+		assert.Equal(suite.T(), resolutionRepaired, incidents[0].Resolution)
+	}
+}
+
+func (suite *CommandsIntegrationTestSuite) TestRepairCommandReplicaMismatch() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.corruptTestFile("foo/bar")
+	// Replica has the same corruption
+	replica := suite.copyTempDir()
+	defer os.RemoveAll(replica)
+
+	cmd := &Repair{
+		From:      flags.Filename(replica),
+		Arguments: PathArguments{Path: flags.Filename(suite.tempDir)},
+		logger:    suite.logger,
+	}
+	suite.clearLog()
+	assert.NotNil(suite.T(), cmd.Execute([]string{}))
+	suite.LogContains("Not restoring foo/bar (flagged): ")
+	suite.LogContains("doesn't match the last known-good checksum")
+	suite.LogContains("Restored 0 of 1 files")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestHealCommand() {
+	suite.writeTestFile("foo/bar", strings.Repeat(helloWorldString, 1000))
+	suite.writeTestFile("foo/baz", "another file")
+	cmd := suite.generateCommand(suite.tempDir)
+	cmd.Parity = 10
+	err := cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Computed 10% parity for 2 files")
+
+	suite.corruptTestFile("foo/bar")
+	// Parity of the known-good content is kept
+	suite.clearLog()
+	err = cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains("Computed 10% parity for 0 files")
+
+	heal := &Heal{
+		DryRun:    true,
+		Arguments: PathArguments{Path: flags.Filename(suite.tempDir)},
+		logger:    suite.logger,
+	}
+	suite.clearLog()
+	assert.Nil(suite.T(), heal.Execute([]string{}))
+	suite.LogContains("Would heal foo/bar")
+	assert.NotNil(suite.T(), suite.validateCommand().Execute([]string{}))
+
+	heal.DryRun = false
+	suite.clearLog()
+	assert.Nil(suite.T(), heal.Execute([]string{}))
+	suite.LogContains("Healed 1 of 1 flagged files from parity.")
+	assert.Nil(suite.T(), suite.validateCommand().Execute([]string{}))
+}
+
+func (suite *CommandsIntegrationTestSuite) TestRepairAndHealThroughSymlinks() {
+	suite.writeTestFile("foo/bar", strings.Repeat(helloWorldString, 1000))
+	assert.Nil(suite.T(), os.Symlink("foo/bar", filepath.Join(suite.tempDir, "link")))
+	assert.Nil(suite.T(), os.Symlink("foo", filepath.Join(suite.tempDir, "dirlink")))
+	generate := suite.generateCommand(suite.tempDir)
+	generate.FollowSymlinks = true
+	generate.Parity = 10
+	assert.Nil(suite.T(), generate.Execute([]string{}))
+	// The file is found at three paths, but gets parity once
+	suite.LogContains("Computed 10% parity for 1 files")
+	replica := suite.copyTempDir()
+	defer os.RemoveAll(replica)
+	assertLinksKept := func() {
+		for _, link := range []string{"link", "dirlink"} {
+			info, err := os.Lstat(filepath.Join(suite.tempDir, link))
+			assert.Nil(suite.T(), err)
+			assert.True(suite.T(), info.Mode()&os.ModeSymlink != 0, link)
+		}
+	}
+
+	suite.corruptTestFile("foo/bar")
+	heal := &Heal{
+		Arguments: PathArguments{Path: flags.Filename(suite.tempDir)},
+		logger:    suite.logger,
+	}
+	suite.clearLog()
+	assert.Nil(suite.T(), heal.Execute([]string{}))
+	suite.LogContains("Healed foo/bar\n")
+	suite.LogContains("Healed 3 of 3 flagged files from parity.")
+	assertLinksKept()
+
+	suite.corruptTestFile("foo/bar")
+	repair := &Repair{
+		From:      flags.Filename(replica),
+		Arguments: PathArguments{Path: flags.Filename(suite.tempDir)},
+		logger:    suite.logger,
+	}
+	suite.clearLog()
+	assert.Nil(suite.T(), repair.Execute([]string{}))
+	suite.LogContains("Restored foo/bar (flagged)\n")
+	suite.LogContains("Restored 1 of 1 files")
+	assertLinksKept()
+
+	suite.clearLog()
+	validate := suite.validateCommand()
+	validate.FollowSymlinks = true
+	assert.Nil(suite.T(), validate.Execute([]string{}))
+	// The file at its three paths, and the linked directory
+	suite.LogContains("Unchanged paths: 4\n")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestHealCommandWithoutParity() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.corruptTestFile("foo/bar")
+	heal := &Heal{
+		Arguments: PathArguments{Path: flags.Filename(suite.tempDir)},
+		logger:    suite.logger,
+	}
+	suite.clearLog()
+	assert.NotNil(suite.T(), heal.Execute([]string{}))
+	suite.LogContains("Not healing foo/bar: no parity for foo/bar")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestIncidentsCommand() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	incidentsCmd := &Incidents{
+		Arguments: PathArguments{Path: flags.Filename(suite.tempDir)},
+		logger:    suite.logger,
+	}
+	suite.clearLog()
+	assert.Nil(suite.T(), incidentsCmd.Execute([]string{}))
+	suite.LogContains("No incidents.")
+
+	suite.corruptTestFile("foo/bar")
+	assert.NotNil(suite.T(), suite.validateCommand().Execute([]string{}))
+	assert.NotNil(suite.T(), suite.validateCommand().Execute([]string{}))
+	suite.clearLog()
+	assert.Nil(suite.T(), incidentsCmd.Execute([]string{}))
+	suite.LogContains(fmt.Sprintf("Incidents for %s:\n    OPEN    foo/bar: flagged 2 times since ", suite.tempDir))
+
+	// Restoring the original content closes the incident
+	suite.corruptTestFile("foo/bar")
+	assert.Nil(suite.T(), suite.validateCommand().Execute([]string{}))
+	suite.clearLog()
+	assert.Nil(suite.T(), incidentsCmd.Execute([]string{}))
+	suite.LogContains("CLOSED  foo/bar: flagged 2 times from ")
+	suite.LogContains(", repaired ")
+
+	suite.clearLog()
+	incidentsCmd.Open = true
+	assert.Nil(suite.T(), incidentsCmd.Execute([]string{}))
+	suite.LogContains("No incidents.")
+}
+
+func (suite *CommandsIntegrationTestSuite) TestAcceptCommandResolvesIncidents() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.corruptTestFile("foo/bar")
+	assert.NotNil(suite.T(), suite.validateCommand().Execute([]string{}))
+
+	cmd := &Accept{
+		Arguments: AcceptArguments{
+			Root:  flags.Filename(suite.tempDir),
+			Paths: []flags.Filename{flags.Filename(filepath.Join(suite.tempDir, "foo/bar"))},
+		},
+		logger: suite.logger,
+	}
+	assert.Nil(suite.T(), cmd.Execute([]string{}))
+
+	incidents, err := DefaultConfig().ManifestStorage().IncidentsForPath(suite.tempDir)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), incidents, 1) {
+		assert.False(suite.T(), incidents[0].Open())
+		assert.Equal(suite.T(), resolutionAccepted, incidents[0].Resolution)
+	}
+}
+
+func (suite *CommandsIntegrationTestSuite) TestCheckIgnoreCommand() {
+	suite.writeTestFile(ignoreFileName, "*.tmp\n")
+	suite.writeTestFile("foo/bar.tmp", "temporary")
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+
+	suite.clearLog()
+	path := filepath.Join(suite.tempDir, "foo/bar.tmp")
+	cmd := &CheckIgnore{
+		Arguments: PathArguments{Path: flags.Filename(path)},
+		logger:    suite.logger,
+	}
+	err = cmd.Execute([]string{})
+	assert.Nil(suite.T(), err)
+	suite.LogContains(fmt.Sprintf("%s is ignored by %s:1:*.tmp\n", path, filepath.Join(suite.tempDir, ignoreFileName)))
+
+	cmd.Arguments.Path = flags.Filename(suite.homeDir)
+	err = cmd.Execute([]string{})
+	assert.NotNil(suite.T(), err)
+}
+
 func (suite *CommandsIntegrationTestSuite) TestCompare() {
 	suite.writeTestFile("foo/bar", helloWorldString)
 	oldTempDir := suite.copyTempDir()
@@ -286,7 +1047,7 @@ func (suite *CommandsIntegrationTestSuite) TestCompareWithFailures() {
 	suite.LogContains("Added paths: 1\n    foo/added")
 	suite.LogContains("Deleted paths: 1\n    foo/deleted")
 	suite.LogContains("Modified paths: 1\n    foo/modified")
-	suite.LogContains("Flagged paths: 1\n    foo/flagged")
+	suite.LogContains("Flagged paths: 1\n    foo/flagged (bit flip, high confidence)\n")
 }
 
 func (suite *CommandsIntegrationTestSuite) TestCompareWithRenames() {
@@ -313,6 +1074,49 @@ func (suite *CommandsIntegrationTestSuite) TestCompareWithRenames() {
 	suite.LogContains("Renamed paths: 1\n    foo/testfile -> foo/testfile2")
 }
 
+func (suite *CommandsIntegrationTestSuite) TestVote() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	suite.writeTestFile("foo/baz", "another file")
+	second := suite.copyTempDir()
+	defer os.RemoveAll(second)
+	third := suite.copyTempDir()
+	defer os.RemoveAll(third)
+	suite.corruptTestFile("foo/bar")
+
+	cmd := &Vote{
+		Plan: true,
+		Arguments: VoteArguments{Paths: []flags.Filename{
+			flags.Filename(suite.tempDir),
+			flags.Filename(second),
+			flags.Filename(third),
+		}},
+		logger: suite.logger,
+	}
+	err := cmd.Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains("Unanimous paths: 1\n")
+	suite.LogContains(fmt.Sprintf("Majority paths: 1\n    %s (2 of 3 copies agree; likely corrupt in %q)", filepath.Join("foo", "bar"), suite.tempDir))
+	suite.LogContains("Repair plan: 1 copies\n")
+	suite.LogContains(fmt.Sprintf("-> %s\n", filepath.Join(suite.tempDir, "foo", "bar")))
+}
+
+func (suite *CommandsIntegrationTestSuite) TestVoteTiebreakWithStoredManifest() {
+	suite.writeTestFile("foo/bar", helloWorldString)
+	err := suite.generateCommand(suite.tempDir).Execute([]string{})
+	assert.Nil(suite.T(), err)
+	replica := suite.copyTempDir()
+	defer os.RemoveAll(replica)
+	suite.corruptTestFile("foo/bar")
+
+	cmd := &Vote{
+		Arguments: VoteArguments{Paths: []flags.Filename{flags.Filename(suite.tempDir), flags.Filename(replica)}},
+		logger:    suite.logger,
+	}
+	err = cmd.Execute([]string{})
+	assert.NotNil(suite.T(), err)
+	suite.LogContains(fmt.Sprintf("Tiebreak paths: 1\n    %s (1 of 2 copies match a stored manifest; likely corrupt in %q)", filepath.Join("foo", "bar"), suite.tempDir))
+}
+
 func (suite *CommandsIntegrationTestSuite) TestCompareLatestManifests() {
 	suite.writeTestFile("foo/bar", helloWorldString)
 	err := suite.generateCommand(suite.tempDir).Execute([]string{})
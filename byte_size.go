@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a number of bytes that can be given on the command line with a
+// binary unit suffix, e.g. "500M" or "2T".
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+func parseByteSize(value string) (ByteSize, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	multiplier := ByteSize(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return ByteSize(n * float64(multiplier)), nil
+}
+
+// UnmarshalFlag implements flags.Unmarshaler.
+func (b *ByteSize) UnmarshalFlag(value string) error {
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	return b.UnmarshalFlag(value.Value)
+}
+
+func (b ByteSize) String() string {
+	for _, unit := range byteSizeUnits {
+		if b >= unit.size {
+			return fmt.Sprintf("%.1f %siB", float64(b)/float64(unit.size), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", int64(b))
+}
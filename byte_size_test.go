@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for value, expected := range map[string]ByteSize{
+		"100":    100,
+		"2K":     2048,
+		"1.5m":   1536 * 1024,
+		"500GB":  500 << 30,
+		" 2T ":   2 << 40,
+		"0":      0,
+		"10 KiB": -1,
+		"-1":     -1,
+		"":       -1,
+	} {
+		size, err := parseByteSize(value)
+		if expected < 0 {
+			assert.NotNil(t, err, value)
+		} else {
+			assert.Nil(t, err, value)
+			assert.Equal(t, expected, size, value)
+		}
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	assert.Equal(t, "512 B", ByteSize(512).String())
+	assert.Equal(t, "1.5 KiB", ByteSize(1536).String())
+	assert.Equal(t, "2.0 GiB", ByteSize(2<<30).String())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// Ways to keep hashing from reading through (and filling) the page cache
+const (
+	// Read with direct I/O, which skips the cache entirely
+	cacheModeDirect = "direct"
+	// Evict the file's cached pages before and after reading it
+	cacheModeEvict = "evict"
+)
+
+// Direct I/O needs buffers, lengths and offsets aligned to the device's
+// logical block size; this covers the common sizes.
+const directIOAlignment = 4096
+
+// alignedBuffer returns a buffer whose start is aligned for direct I/O.
+func alignedBuffer(size int) []byte {
+	buffer := make([]byte, size+directIOAlignment)
+	offset := 0
+	if remainder := int(uintptr(unsafe.Pointer(&buffer[0])) % directIOAlignment); remainder != 0 {
+		offset = directIOAlignment - remainder
+	}
+	return buffer[offset : offset+size]
+}
+
+// directReader reads a file opened for direct I/O. A short read means the end
+// of the file, and reading on from the unaligned offset that leaves would fail,
+// so later reads return io.EOF instead.
+type directReader struct {
+	file *os.File
+	eof  bool
+}
+
+func (r *directReader) Read(p []byte) (int, error) {
+	if r.eof {
+		return 0, io.EOF
+	}
+	n, err := r.file.Read(p)
+	if err == nil && n < len(p) {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *directReader) Seek(offset int64, whence int) (int64, error) {
+	r.eof = false
+	return r.file.Seek(offset, whence)
+}
+
+func (r *directReader) Close() error {
+	return r.file.Close()
+}
+
+func checkCacheMode(mode string) error {
+	if mode != "" && mode != cacheModeDirect && mode != cacheModeEvict {
+		return fmt.Errorf("cache bypass must be %q or %q, not %q", cacheModeDirect, cacheModeEvict, mode)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openDirect opens a file for direct I/O, if its filesystem supports it (tmpfs,
+// for one, doesn't).
+func openDirect(path string) (*os.File, bool) {
+	file, err := os.OpenFile(path, os.O_RDONLY|unix.O_DIRECT, 0)
+	if err != nil {
+		return nil, false
+	}
+	return file, true
+}
+
+// evictFromCache asks the kernel to drop a file's cached pages, so that the
+// next read comes from the disk. It's best effort; failures are ignored.
+func evictFromCache(file *os.File) {
+	unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// openDirect would open a file for direct I/O, but that isn't supported on this
+// platform.
+func openDirect(path string) (*os.File, bool) {
+	return nil, false
+}
+
+// evictFromCache would drop a file's cached pages so that the next read comes
+// from the disk, but there is no portable way to do so on this platform.
+func evictFromCache(file *os.File) {
+}
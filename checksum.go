@@ -2,51 +2,282 @@ package main
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
 	"hash"
+	"hash/crc32"
+	"hash/crc64"
 	"io"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
 )
 
-type sha1Reader struct {
-	hash       hash.Hash
-	reader     io.ReadSeeker
+// Algorithm used when none is specified and no previous manifest exists. Also
+// assumed for manifests written before the algorithm was recorded.
+const defaultHashAlgorithm = "sha1"
+
+// Read buffer size for checksums; larger buffers don't measurably help (see
+// benchmarks in checksum_test.go).
+const checksumBufferSize = 1024 * 1024
+
+// Hash algorithms available for file checksums, keyed by the name used on the
+// command line and recorded in manifests.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha1":    sha1.New,
+	"sha256":  sha256.New,
+	"sha512":  sha512.New,
+	"blake2b": newBlake2b,
+	// Not cryptographic, but much faster; fine for detecting bitrot
+	"crc64": newCRC64,
+}
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// Chunk hashes only need to locate changes within a file, so they use a fast
+// CRC rather than the file's hash algorithm.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func newBlake2b() hash.Hash {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		// Only possible with an oversized key
+		panic(err)
+	}
+	return h
+}
+
+func newCRC64() hash.Hash {
+	return crc64.New(crc64Table)
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	constructor, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q (available: %s)", algorithm, strings.Join(hashAlgorithmNames(), ", "))
+	}
+	return constructor(), nil
+}
+
+func hashAlgorithmNames() []string {
+	names := []string{}
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Buffers are reused across files, one pool per buffer size.
+var bufferPools sync.Map
+
+func getBufferPool(size int) *sync.Pool {
+	pool, ok := bufferPools.Load(size)
+	if !ok {
+		pool, _ = bufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				// Aligned in case the file is read with direct I/O
+				b := alignedBuffer(size)
+				return &b
+			},
+		})
+	}
+	return pool.(*sync.Pool)
+}
+
+// checksumReader computes checksums of a file with one or more algorithms in a
+// single pass, optionally along with a hash of each fixed-size chunk.
+type checksumReader struct {
+	hashes     []hash.Hash
+	file       *os.File
+	reader     io.ReadSeekCloser
 	bufferSize int
+	// Evict the file from the page cache before and after reading
+	evict bool
+	// Limits on reading, if set
+	throttle *Throttle
+	// Told of each read, if set
+	watch     *stallWatch
+	chunkSize int64
+	chunkHash hash.Hash32
+	// Bytes hashed into the current chunk
+	chunkFill int64
+	chunks    []string
 }
 
-func newSha1Reader(path string, bufferSize int) (*sha1Reader, error) {
+func newChecksumReader(path string, bufferSize int, algorithms ...string) (*checksumReader, error) {
+	hashes := []hash.Hash{}
+	for _, algorithm := range algorithms {
+		h, err := newHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	return &sha1Reader{
-		hash:       sha1.New(),
+	return &checksumReader{
+		hashes:     hashes,
+		file:       file,
 		reader:     file,
 		bufferSize: bufferSize,
 	}, nil
 }
 
-func (r *sha1Reader) SHA1Sum() ([]byte, error) {
+// hashChunks makes the reader also hash each chunkSize bytes of the file.
+func (r *checksumReader) hashChunks(chunkSize int64) {
+	r.chunkSize = chunkSize
+	r.chunkHash = crc32.New(crc32cTable)
+}
+
+// bypassCache makes the reader avoid the page cache in one of the cache modes.
+// Direct I/O falls back to eviction where the filesystem doesn't support it.
+func (r *checksumReader) bypassCache(mode string) {
+	if mode == cacheModeDirect {
+		if file, ok := openDirect(r.file.Name()); ok {
+			r.file.Close()
+			r.file = file
+			r.reader = &directReader{file: file}
+			return
+		}
+	}
+	r.evict = mode != ""
+}
+
+// Chunks returns the hex-encoded chunk hashes, after Sums.
+func (r *checksumReader) Chunks() []string {
+	return r.chunks
+}
+
+// Sums returns the checksums in the order the algorithms were given, and
+// closes the file.
+func (r *checksumReader) Sums() ([][]byte, error) {
+	defer r.Close()
 	_, err := r.reader.Seek(0, 0)
 	if err != nil {
-		return []byte{}, err
+		return nil, err
+	}
+	if r.evict {
+		evictFromCache(r.file)
 	}
 	err = r.readAll()
 	if err != nil {
-		return []byte{}, err
+		return nil, err
+	}
+	if r.evict {
+		// Don't push other data out of the cache
+		evictFromCache(r.file)
+	}
+	if r.chunkFill > 0 {
+		r.finishChunk()
+	}
+	sums := [][]byte{}
+	for _, h := range r.hashes {
+		sums = append(sums, h.Sum(nil))
 	}
-	return r.hash.Sum(nil), nil
+	return sums, nil
 }
 
-func (r *sha1Reader) readAll() (err error) {
-	for {
-		b := make([]byte, r.bufferSize, r.bufferSize)
-		n, err := r.reader.Read(b)
-		if err != nil && err != io.EOF {
-			return err
+func (r *checksumReader) Close() error {
+	return r.reader.Close()
+}
+
+type readChunk struct {
+	buffer *[]byte
+	n      int
+	err    error
+}
+
+// readAll hashes the whole file with two buffers, so the next chunk is read
+// while the previous one is being hashed.
+func (r *checksumReader) readAll() error {
+	pool := getBufferPool(r.bufferSize)
+	first := pool.Get().(*[]byte)
+	defer pool.Put(first)
+
+	// Small files fit in one buffer and don't need read-ahead
+	n, err := io.ReadFull(r.reader, *first)
+	r.readDone(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		r.write((*first)[:n])
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	second := pool.Get().(*[]byte)
+	defer pool.Put(second)
+
+	free := make(chan *[]byte, 2)
+	filled := make(chan readChunk, 1)
+	free <- second
+	go func() {
+		for buffer := range free {
+			n, err := io.ReadFull(r.reader, *buffer)
+			r.readDone(n)
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			filled <- readChunk{buffer: buffer, n: n, err: err}
+			if err != nil {
+				// Stop before handing back any buffer, so both can be
+				// returned to the pool
+				return
+			}
+		}
+	}()
+
+	r.write((*first)[:n])
+	free <- first
+	for chunk := range filled {
+		if chunk.err != nil && chunk.err != io.EOF {
+			return chunk.err
 		}
-		r.hash.Write(b[:n])
-		if err == io.EOF {
+		r.write((*chunk.buffer)[:chunk.n])
+		if chunk.err == io.EOF {
 			return nil
 		}
+		free <- chunk.buffer
 	}
+	return nil
+}
+
+// readDone reports a read of n bytes as progress and charges it to the read
+// limits. Waiting on the limits doesn't count as a stall.
+func (r *checksumReader) readDone(n int) {
+	r.watch.pause()
+	r.throttle.chargeRead(n)
+	r.watch.resume()
+}
+
+func (r *checksumReader) write(data []byte) {
+	for _, h := range r.hashes {
+		h.Write(data)
+	}
+	for r.chunkSize > 0 && len(data) > 0 {
+		n := r.chunkSize - r.chunkFill
+		if n > int64(len(data)) {
+			n = int64(len(data))
+		}
+		r.chunkHash.Write(data[:n])
+		r.chunkFill += n
+		data = data[n:]
+		if r.chunkFill == r.chunkSize {
+			r.finishChunk()
+		}
+	}
+}
+
+func (r *checksumReader) finishChunk() {
+	r.chunks = append(r.chunks, hex.EncodeToString(r.chunkHash.Sum(nil)))
+	r.chunkHash.Reset()
+	r.chunkFill = 0
 }
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -17,7 +22,179 @@ func TestBufferSizeConfiguration(t *testing.T) {
 	populateTestDirectory(t, tempDir)
 	bufferSize := 1024
 	path := writeTestFile(t, tempDir, "foo", helloWorldString)
-	reader, err := newSha1Reader(path, bufferSize)
+	reader, err := newChecksumReader(path, bufferSize, defaultHashAlgorithm)
 	assert.Nil(t, err)
+	defer reader.Close()
 	assert.Equal(t, 1024, reader.bufferSize)
 }
+
+func TestHashAlgorithms(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+
+	defer os.RemoveAll(tempDir)
+
+	path := writeTestFile(t, tempDir, "foo", helloWorldString)
+	expected := map[string]string{
+		"sha1":    helloWorldChecksum,
+		"sha256":  "dff770fab8b569686bad419a25a97033f90e89943dea564a91d6a4e7327fbfa9",
+		"sha512":  "500394225629496256cccc2724ce6fb1a334342e2d19f052f73f01d957220ce120ea7b7fd023801139d2cdd605ceb66f8cc706ebe422c25acabd72e91af13ffa",
+		"blake2b": "750e8b7f6709aa62848fe2154f51eb2c0838f765be656620052e855183d03862",
+		"crc64":   "e1db9e5e86ded925",
+	}
+	algorithms := hashAlgorithmNames()
+	assert.ElementsMatch(t, algorithms, []string{"sha1", "sha256", "sha512", "blake2b", "crc64"})
+
+	checksums, err := generateChecksums(path, algorithms)
+	assert.Nil(t, err)
+	for i, algorithm := range algorithms {
+		assert.Equal(t, expected[algorithm], checksums[i], algorithm)
+	}
+}
+
+func TestChecksumReadAhead(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+
+	defer os.RemoveAll(tempDir)
+
+	// Buffer sizes spanning several reads, an exact multiple, and one read
+	for _, bufferSize := range []int{3, len(helloWorldString), 1024} {
+		path := writeTestFile(t, tempDir, "foo", helloWorldString)
+		reader, err := newChecksumReader(path, bufferSize, defaultHashAlgorithm)
+		assert.Nil(t, err)
+		sums, err := reader.Sums()
+		assert.Nil(t, err)
+		assert.Equal(t, helloWorldChecksum, hex.EncodeToString(sums[0]), "buffer size %d", bufferSize)
+		// Sums closes the file
+		assert.NotNil(t, reader.Close())
+	}
+}
+
+func TestUnknownHashAlgorithm(t *testing.T) {
+	_, err := newHash("md4")
+	assert.EqualError(t, err, `unknown hash algorithm "md4" (available: blake2b, crc64, sha1, sha256, sha512)`)
+}
+
+func writeBenchmarkFile(b *testing.B, dir, name string, size int) string {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	assert.Nil(b, err)
+	path := filepath.Join(dir, name)
+	assert.Nil(b, ioutil.WriteFile(path, data, 0644))
+	return path
+}
+
+// allocatingChecksum hashes a file the way it was done before read buffers
+// were pooled, allocating a buffer for every read, as a baseline.
+func allocatingChecksum(path string, bufferSize int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h, err := newHash(defaultHashAlgorithm)
+	if err != nil {
+		return err
+	}
+	for {
+		buf := make([]byte, bufferSize)
+		n, err := file.Read(buf)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		h.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+	}
+	h.Sum(nil)
+	return nil
+}
+
+func pooledChecksum(path string, bufferSize int) error {
+	reader, err := newChecksumReader(path, bufferSize, defaultHashAlgorithm)
+	if err != nil {
+		return err
+	}
+	_, err = reader.Sums()
+	return err
+}
+
+var benchmarkReaders = []struct {
+	name     string
+	checksum func(path string, bufferSize int) error
+}{
+	{"allocating", allocatingChecksum},
+	{"pooled", pooledChecksum},
+}
+
+func benchmarkChecksums(b *testing.B, paths []string, size int64, bufferSize int, checksum func(string, int) error) {
+	b.ReportAllocs()
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		for _, path := range paths {
+			if err := checksum(path, bufferSize); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkChecksumLargeFile(b *testing.B) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(b, err)
+	defer os.RemoveAll(tempDir)
+
+	size := 64 * 1024 * 1024
+	path := writeBenchmarkFile(b, tempDir, "large", size)
+	for _, reader := range benchmarkReaders {
+		for _, bufferSize := range []int{64 * 1024, 256 * 1024, 1024 * 1024, 4 * 1024 * 1024, 10 * 1024 * 1024} {
+			b.Run(fmt.Sprintf("reader=%s/buffer=%dKiB", reader.name, bufferSize/1024), func(b *testing.B) {
+				benchmarkChecksums(b, []string{path}, int64(size), bufferSize, reader.checksum)
+			})
+		}
+	}
+}
+
+func BenchmarkChecksumSmallFiles(b *testing.B) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(b, err)
+	defer os.RemoveAll(tempDir)
+
+	size := 4 * 1024
+	paths := []string{}
+	for i := 0; i < 1000; i++ {
+		paths = append(paths, writeBenchmarkFile(b, tempDir, fmt.Sprintf("small%d", i), size))
+	}
+	for _, reader := range benchmarkReaders {
+		b.Run("reader="+reader.name, func(b *testing.B) {
+			benchmarkChecksums(b, paths, int64(size*len(paths)), checksumBufferSize, reader.checksum)
+		})
+	}
+}
+
+func TestChecksumsBypassingCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+
+	defer os.RemoveAll(tempDir)
+
+	// Sizes that end on and off block and buffer boundaries
+	for _, size := range []int{0, 100, directIOAlignment, checksumBufferSize + 123, 2 * checksumBufferSize} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		assert.Nil(t, err)
+		path := filepath.Join(tempDir, fmt.Sprintf("file%d", size))
+		assert.Nil(t, ioutil.WriteFile(path, data, 0644))
+
+		expected, expectedChunks, err := hashFile(path, []string{"sha1"}, hashOptions{chunkSize: 1000})
+		assert.Nil(t, err)
+		for _, mode := range []string{cacheModeDirect, cacheModeEvict} {
+			checksums, chunks, err := hashFile(path, []string{"sha1"}, hashOptions{chunkSize: 1000, cacheMode: mode})
+			assert.Nil(t, err, mode)
+			assert.Equal(t, expected, checksums, "%s, %d bytes", mode, size)
+			assert.Equal(t, expectedChunks, chunks, "%s, %d bytes", mode, size)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ByteRange is a range of bytes in a file, from Start up to but not including
+// End.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+func (r ByteRange) Len() int64 {
+	return r.End - r.Start
+}
+
+// String formats the range with an inclusive end, as in HTTP ranges.
+func (r ByteRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End-1)
+}
+
+// changedRanges compares the chunk hashes of two versions of a file, returning
+// the byte ranges whose chunks differ, merged where they adjoin. Bytes only in
+// the longer version count as changed.
+func changedRanges(oldChunks, newChunks []string, chunkSize, oldSize, newSize int64) []ByteRange {
+	size := oldSize
+	if newSize > size {
+		size = newSize
+	}
+	ranges := []ByteRange{}
+	for start := int64(0); start < size; start += chunkSize {
+		i := start / chunkSize
+		end := start + chunkSize
+		if end > size {
+			end = size
+		}
+		// The final chunk of the shorter version may be partial, so compare
+		// sizes too
+		same := i < int64(len(oldChunks)) && i < int64(len(newChunks)) &&
+			oldChunks[i] == newChunks[i] &&
+			(end <= oldSize) == (end <= newSize)
+		if same {
+			continue
+		}
+		if len(ranges) > 0 && ranges[len(ranges)-1].End == start {
+			ranges[len(ranges)-1].End = end
+		} else {
+			ranges = append(ranges, ByteRange{Start: start, End: end})
+		}
+	}
+	return ranges
+}
+
+// Describes the ranges and the total bytes they cover.
+func describeRanges(ranges []ByteRange) string {
+	var total int64
+	descriptions := []string{}
+	for _, r := range ranges {
+		total += r.Len()
+		descriptions = append(descriptions, r.String())
+	}
+	return fmt.Sprintf("%s changed in bytes %s", ByteSize(total), strings.Join(descriptions, ", "))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangedRanges(t *testing.T) {
+	old := []string{"a", "b", "c", "d"}
+	assert.Empty(t, changedRanges(old, []string{"a", "b", "c", "d"}, 10, 40, 40))
+	assert.Equal(t, []ByteRange{{10, 30}}, changedRanges(old, []string{"a", "x", "y", "d"}, 10, 40, 40))
+	assert.Equal(t, []ByteRange{{0, 10}, {30, 40}}, changedRanges(old, []string{"x", "b", "c", "y"}, 10, 40, 40))
+
+	// Truncated and extended
+	assert.Equal(t, []ByteRange{{30, 40}}, changedRanges(old, []string{"a", "b", "c"}, 10, 40, 30))
+	// A partial final chunk changes when the file grows
+	assert.Equal(t, []ByteRange{{30, 45}}, changedRanges(old, []string{"a", "b", "c", "x", "e"}, 10, 35, 45))
+
+	assert.Equal(t, "10-29", ByteRange{10, 30}.String())
+	assert.Equal(t, "20 B changed in bytes 0-9, 30-39", describeRanges([]ByteRange{{0, 10}, {30, 40}}))
+}
+
+func TestChunkHashes(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	content := []byte("0123456789abcdefghij0123")
+	path := filepath.Join(tempDir, "foo")
+	assert.Nil(t, ioutil.WriteFile(path, content, 0644))
+	// Buffer smaller than chunks, so chunks span reads
+	reader, err := newChecksumReader(path, 7, "sha1")
+	assert.Nil(t, err)
+	reader.hashChunks(10)
+	_, err = reader.Sums()
+	assert.Nil(t, err)
+
+	table := crc32.MakeTable(crc32.Castagnoli)
+	expected := []string{}
+	for start := 0; start < len(content); start += 10 {
+		end := start + 10
+		if end > len(content) {
+			end = len(content)
+		}
+		sum := crc32.Checksum(content[start:end], table)
+		expected = append(expected, fmt.Sprintf("%08x", sum))
+	}
+	assert.Equal(t, expected, reader.Chunks())
+	assert.Len(t, reader.Chunks(), 3)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+)
+
+// Kinds of corruption
+const (
+	corruptionRewrite    = "rewrite"
+	corruptionTruncation = "truncation"
+	corruptionZeroFill   = "zero-filled"
+	corruptionOnesFill   = "0xFF-filled"
+	corruptionOverwrite  = "overwritten"
+	corruptionBitFlip    = "bit flip"
+	corruptionAppend     = "appended"
+	corruptionUnknown    = "unknown"
+)
+
+// Order in which to triage kinds of corruption, scariest first
+var corruptionSeverity = map[string]int{
+	corruptionRewrite:    0,
+	corruptionTruncation: 1,
+	corruptionZeroFill:   2,
+	corruptionOnesFill:   3,
+	corruptionOverwrite:  4,
+	corruptionBitFlip:    5,
+	corruptionAppend:     6,
+	corruptionUnknown:    7,
+}
+
+const (
+	confidenceHigh   = "high"
+	confidenceMedium = "medium"
+	confidenceLow    = "low"
+)
+
+const (
+	// Without an exact diff, fill patterns are looked for in blocks the size of
+	// a disk sector
+	fillBlockSize = 512
+	// Changes to at most this many bits are bit flips
+	maxFlippedBits = 8
+	// Changed ranges larger than this aren't read to look for fill patterns
+	maxClassifyReadSize = 64 * 1024 * 1024
+)
+
+// Corruption is a guess at how a flagged file was damaged.
+type Corruption struct {
+	Kind       string
+	Confidence string
+}
+
+func (c Corruption) String() string {
+	return fmt.Sprintf("%s, %s confidence", c.Kind, c.Confidence)
+}
+
+// Known reports whether anything could be said about the corruption.
+func (c Corruption) Known() bool {
+	return c.Kind != corruptionUnknown
+}
+
+// classifyCorruption guesses how a file changed between two records, from
+// their sizes, the ranges their chunk hashes show changed (nil without chunk
+// hashes), and the current content at path. If reference is set, it's a copy
+// of the old content, as when comparing two directories, and the files are
+// compared byte for byte instead. Files that can't be read are classified from
+// the records alone.
+func classifyCorruption(old, current ChecksumRecord, chunkSize int64, changed []ByteRange, path, reference string) Corruption {
+	// Exact ranges are certain; chunk ranges only bound where the damage is
+	exact := false
+	if reference != "" {
+		diff, err := diffFiles(reference, path)
+		if err == nil {
+			if current.Size == old.Size && diff.bits <= maxFlippedBits {
+				return Corruption{corruptionBitFlip, confidenceHigh}
+			}
+			changed = diff.ranges
+			exact = true
+			chunkSize = 1
+		}
+	}
+	confidence := confidenceMedium
+	if exact {
+		confidence = confidenceHigh
+	}
+	if changed == nil {
+		if current.Size < old.Size {
+			return Corruption{corruptionTruncation, confidenceLow}
+		}
+		if current.Size > old.Size {
+			return Corruption{corruptionAppend, confidenceLow}
+		}
+		return Corruption{corruptionUnknown, confidenceLow}
+	}
+
+	changedSize := rangesTotal(changed)
+	if current.Size != old.Size {
+		kind := corruptionTruncation
+		common := current.Size
+		if current.Size > old.Size {
+			kind = corruptionAppend
+			common = old.Size
+		}
+		// Only the end changed, from the chunk containing the end of the
+		// shorter version
+		if len(changed) == 1 && changed[0].Start >= common/chunkSize*chunkSize {
+			return Corruption{kind, confidence}
+		}
+		if rewritten(changedSize, common) {
+			return Corruption{corruptionRewrite, confidence}
+		}
+		return Corruption{kind, confidenceLow}
+	}
+
+	if rewritten(changedSize, current.Size) {
+		return Corruption{corruptionRewrite, confidence}
+	}
+	if changedSize <= maxClassifyReadSize {
+		if fill := fillPattern(path, changed, exact); fill != "" {
+			// Chunks only had to contain a block of the fill, which plenty of
+			// file formats have anyway
+			if !exact {
+				confidence = confidenceLow
+			}
+			return Corruption{fill, confidence}
+		}
+	}
+	if oneArea(changed) {
+		// Damage confined to one area, but more than the few bits of a bit
+		// flip. Without a copy, only the chunks are known, so flips can't be
+		// told apart.
+		if !exact {
+			confidence = confidenceLow
+		}
+		return Corruption{corruptionOverwrite, confidence}
+	}
+	return Corruption{corruptionUnknown, confidenceLow}
+}
+
+// Reports whether so much of a file changed that it's better described as
+// rewritten than damaged.
+func rewritten(changedSize, size int64) bool {
+	return changedSize > size/2
+}
+
+// Reports whether changed ranges make up one damaged area. Overwritten bytes
+// that happen to match leave gaps in exact ranges, so ranges that changed most
+// of the bytes they span count as one.
+func oneArea(changed []ByteRange) bool {
+	if len(changed) <= 1 {
+		return len(changed) == 1
+	}
+	span := changed[len(changed)-1].End - changed[0].Start
+	return rangesTotal(changed)*2 > span
+}
+
+func rangesTotal(ranges []ByteRange) int64 {
+	var total int64
+	for _, r := range ranges {
+		total += r.Len()
+	}
+	return total
+}
+
+// Returns the fill kind if all the changed ranges of a file are filled with
+// 0x00 or with 0xFF, or "" if not (or the file can't be read). Exact ranges
+// must be filled entirely; others must each contain a sector-sized block of
+// the fill.
+func fillPattern(path string, ranges []ByteRange, exact bool) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	fills := []struct {
+		kind string
+		b    byte
+	}{{corruptionZeroFill, 0x00}, {corruptionOnesFill, 0xff}}
+	kind := ""
+	for _, r := range ranges {
+		data := make([]byte, r.Len())
+		n, err := file.ReadAt(data, r.Start)
+		if err != nil && err != io.EOF {
+			return ""
+		}
+		data = data[:n]
+		rangeKind := ""
+		for _, fill := range fills {
+			if (exact && len(data) > 0 && allBytes(data, fill.b)) ||
+				(!exact && hasFilledBlock(data, r.Start, fill.b)) {
+				rangeKind = fill.kind
+				break
+			}
+		}
+		if rangeKind == "" || (kind != "" && rangeKind != kind) {
+			return ""
+		}
+		kind = rangeKind
+	}
+	return kind
+}
+
+func allBytes(data []byte, b byte) bool {
+	for _, c := range data {
+		if c != b {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether data, which starts at offset in its file, contains an
+// aligned block filled with b.
+func hasFilledBlock(data []byte, offset int64, b byte) bool {
+	start := (fillBlockSize - offset%fillBlockSize) % fillBlockSize
+	for i := start; i+fillBlockSize <= int64(len(data)); i += fillBlockSize {
+		if allBytes(data[i:i+fillBlockSize], b) {
+			return true
+		}
+	}
+	return false
+}
+
+type fileDiff struct {
+	// Ranges of differing bytes, including any bytes only in the longer file
+	ranges []ByteRange
+	// Differing bits in the bytes both files have
+	bits int64
+}
+
+// diffFiles compares two files byte for byte. It stops early once enough has
+// changed to count as a rewrite, leaving the ranges and bits incomplete.
+func diffFiles(a, b string) (*fileDiff, error) {
+	fileA, err := os.Open(a)
+	if err != nil {
+		return nil, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(b)
+	if err != nil {
+		return nil, err
+	}
+	defer fileB.Close()
+	infoA, err := fileA.Stat()
+	if err != nil {
+		return nil, err
+	}
+	infoB, err := fileB.Stat()
+	if err != nil {
+		return nil, err
+	}
+	common, longer := infoA.Size(), infoB.Size()
+	if common > longer {
+		common, longer = longer, common
+	}
+
+	diff := &fileDiff{ranges: []ByteRange{}}
+	var changedSize int64
+	addRange := func(start, end int64) {
+		changedSize += end - start
+		last := len(diff.ranges) - 1
+		if last >= 0 && diff.ranges[last].End == start {
+			diff.ranges[last].End = end
+		} else {
+			diff.ranges = append(diff.ranges, ByteRange{Start: start, End: end})
+		}
+	}
+	bufferA := make([]byte, checksumBufferSize)
+	bufferB := make([]byte, checksumBufferSize)
+	for offset := int64(0); offset < common; offset += int64(len(bufferA)) {
+		if remaining := common - offset; remaining < int64(len(bufferA)) {
+			bufferA = bufferA[:remaining]
+			bufferB = bufferB[:remaining]
+		}
+		_, err = io.ReadFull(fileA, bufferA)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(fileB, bufferB)
+		if err != nil {
+			return nil, err
+		}
+		for i := range bufferA {
+			if x := bufferA[i] ^ bufferB[i]; x != 0 {
+				diff.bits += int64(bits.OnesCount8(x))
+				addRange(offset+int64(i), offset+int64(i)+1)
+			}
+		}
+		if rewritten(changedSize, common) && diff.bits > maxFlippedBits {
+			return diff, nil
+		}
+	}
+	if longer > common {
+		addRange(common, longer)
+	}
+	return diff, nil
+}
+
+// Classify guesses how a flagged path was corrupted. When the manifests are
+// of different directories, the old copy is compared byte for byte.
+func (comp *ManifestComparison) Classify(path string) Corruption {
+	reference := ""
+	if comp.oldManifest.Path != comp.newManifest.Path {
+		reference = filepath.Join(comp.oldManifest.Path, path)
+	}
+	return classifyCorruption(
+		comp.oldManifest.Entries[path],
+		comp.newManifest.Entries[path],
+		comp.newManifest.ChunkSize,
+		comp.ChangedRanges(path),
+		filepath.Join(comp.newManifest.Path, path),
+		reference,
+	)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes old and new versions of a file and classifies the change between
+// them, using a byte-for-byte diff if compare is set and chunk hashes
+// otherwise.
+func classifyContents(t *testing.T, old, new []byte, compare bool) Corruption {
+	tempDir, err := ioutil.TempDir("", "classify")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	oldPath := filepath.Join(tempDir, "old")
+	newPath := filepath.Join(tempDir, "new")
+	assert.Nil(t, ioutil.WriteFile(oldPath, old, 0644))
+	assert.Nil(t, ioutil.WriteFile(newPath, new, 0644))
+
+	const chunkSize = 1024
+	record := func(path string) ChecksumRecord {
+		_, chunks, err := hashFile(path, nil, hashOptions{chunkSize: chunkSize})
+		assert.Nil(t, err)
+		info, err := os.Stat(path)
+		assert.Nil(t, err)
+		return ChecksumRecord{Size: info.Size(), Chunks: chunks}
+	}
+	oldRecord, newRecord := record(oldPath), record(newPath)
+	if compare {
+		return classifyCorruption(oldRecord, newRecord, 0, nil, newPath, oldPath)
+	}
+	changed := changedRanges(oldRecord.Chunks, newRecord.Chunks, chunkSize, oldRecord.Size, newRecord.Size)
+	return classifyCorruption(oldRecord, newRecord, chunkSize, changed, newPath, "")
+}
+
+func TestClassifyCorruption(t *testing.T) {
+	// Varied content, so that nothing looks like a fill pattern by accident
+	old := make([]byte, 8*1024)
+	for i := range old {
+		old[i] = byte(i*7 + i/256)
+	}
+	modified := func(modify func([]byte) []byte) []byte {
+		return modify(append([]byte{}, old...))
+	}
+
+	truncated := old[:5000]
+	assert.Equal(t, Corruption{corruptionTruncation, confidenceHigh}, classifyContents(t, old, truncated, true))
+	assert.Equal(t, Corruption{corruptionTruncation, confidenceMedium}, classifyContents(t, old, truncated, false))
+
+	appended := append(append([]byte{}, old...), "more"...)
+	assert.Equal(t, Corruption{corruptionAppend, confidenceHigh}, classifyContents(t, old, appended, true))
+	assert.Equal(t, Corruption{corruptionAppend, confidenceMedium}, classifyContents(t, old, appended, false))
+
+	rewritten := bytes.Repeat([]byte("rewritten!"), 800)
+	assert.Equal(t, Corruption{corruptionRewrite, confidenceHigh}, classifyContents(t, old, rewritten, true))
+	assert.Equal(t, Corruption{corruptionRewrite, confidenceMedium}, classifyContents(t, old, rewritten, false))
+
+	zeroed := modified(func(b []byte) []byte {
+		copy(b[2048:3072], make([]byte, 1024))
+		return b
+	})
+	assert.Equal(t, Corruption{corruptionZeroFill, confidenceHigh}, classifyContents(t, old, zeroed, true))
+	// Without a copy, the chunk only has to contain a block of zeroes
+	assert.Equal(t, Corruption{corruptionZeroFill, confidenceLow}, classifyContents(t, old, zeroed, false))
+
+	onesFilled := modified(func(b []byte) []byte {
+		copy(b[512:1024], bytes.Repeat([]byte{0xff}, 512))
+		return b
+	})
+	assert.Equal(t, Corruption{corruptionOnesFill, confidenceHigh}, classifyContents(t, old, onesFilled, true))
+	assert.Equal(t, Corruption{corruptionOnesFill, confidenceLow}, classifyContents(t, old, onesFilled, false))
+
+	flipped := modified(func(b []byte) []byte {
+		b[4000] ^= 0x10
+		return b
+	})
+	assert.Equal(t, Corruption{corruptionBitFlip, confidenceHigh}, classifyContents(t, old, flipped, true))
+	// Only the chunk is known without a copy to compare with, which could have
+	// been overwritten as well
+	assert.Equal(t, Corruption{corruptionOverwrite, confidenceLow}, classifyContents(t, old, flipped, false))
+
+	// A whole chunk overwritten with other data
+	overwritten := modified(func(b []byte) []byte {
+		copy(b[1024:2048], bytes.Repeat([]byte("overwritten!"), 100))
+		return b
+	})
+	assert.Equal(t, Corruption{corruptionOverwrite, confidenceHigh}, classifyContents(t, old, overwritten, true))
+	assert.Equal(t, Corruption{corruptionOverwrite, confidenceLow}, classifyContents(t, old, overwritten, false))
+
+	// Scattered damage
+	scattered := modified(func(b []byte) []byte {
+		for i := 100; i < len(b); i += 2000 {
+			b[i] ^= 0xff
+		}
+		return b
+	})
+	assert.Equal(t, Corruption{corruptionUnknown, confidenceLow}, classifyContents(t, old, scattered, true))
+}
+
+func TestClassifyCorruptionWithoutContent(t *testing.T) {
+	old := ChecksumRecord{Size: 100}
+	assert.Equal(t, Corruption{corruptionTruncation, confidenceLow},
+		classifyCorruption(old, ChecksumRecord{Size: 50}, 0, nil, "/nonexistent", ""))
+	assert.Equal(t, Corruption{corruptionAppend, confidenceLow},
+		classifyCorruption(old, ChecksumRecord{Size: 150}, 0, nil, "/nonexistent", ""))
+	assert.Equal(t, Corruption{corruptionUnknown, confidenceLow},
+		classifyCorruption(old, ChecksumRecord{Size: 100}, 0, nil, "/nonexistent", ""))
+	// A missing reference falls back on the records
+	assert.Equal(t, Corruption{corruptionTruncation, confidenceLow},
+		classifyCorruption(old, ChecksumRecord{Size: 50}, 0, nil, "/nonexistent", "/nonexistent2"))
+}
+
+func TestDiffFilesStopsAtRewrite(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "classify")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	oldPath := filepath.Join(tempDir, "old")
+	newPath := filepath.Join(tempDir, "new")
+	size := 4 * checksumBufferSize
+	assert.Nil(t, ioutil.WriteFile(oldPath, make([]byte, size), 0644))
+	assert.Nil(t, ioutil.WriteFile(newPath, bytes.Repeat([]byte{1}, size), 0644))
+
+	diff, err := diffFiles(oldPath, newPath)
+	assert.Nil(t, err)
+	// Comparing stops after the buffer where more than half has changed
+	assert.Equal(t, int64(3*checksumBufferSize), rangesTotal(diff.ranges))
+	record := ChecksumRecord{Size: int64(size)}
+	assert.Equal(t, Corruption{corruptionRewrite, confidenceHigh},
+		classifyCorruption(record, record, 0, nil, newPath, oldPath))
+}
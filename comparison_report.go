@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // ComparisonReport handles summarizing and formatting the results of a manifest comparison.
@@ -27,25 +31,69 @@ func (report *ComparisonReport) SummaryString() string {
 	}
 	s += "\n\n"
 
+	s += report.exclusionWarning()
 	s += fmt.Sprintf("%d files compared.\n\n", report.mc.TotalChecked())
 
-	s += report.summaryLine("Unchanged", report.mc.UnchangedPaths)
-	s += report.summaryLine("Added", report.mc.AddedPaths)
-	s += report.summaryLine("Deleted", report.mc.DeletedPaths)
-	s += fmt.Sprintf("Renamed paths: %d\n", len(report.mc.RenamedPaths))
-	s += report.summaryLine("Modified", report.mc.ModifiedPaths)
-	s += report.summaryLine("Flagged", report.mc.FlaggedPaths)
+	mc := report.mc
+	s += report.bytesSummaryLine("Unchanged", len(mc.UnchangedPaths), mc.NewBytes(mc.UnchangedPaths))
+	s += report.bytesSummaryLine("Added", len(mc.AddedPaths), mc.NewBytes(mc.AddedPaths))
+	s += report.bytesSummaryLine("Deleted", len(mc.DeletedPaths), mc.OldBytes(mc.DeletedPaths))
+	s += report.bytesSummaryLine("Renamed", len(mc.RenamedPaths), mc.RenamedBytes())
+	s += report.bytesSummaryLine("Modified", len(mc.ModifiedPaths), mc.NewBytes(mc.ModifiedPaths))
+	s += report.bytesSummaryLine("Metadata changed", len(mc.MetadataChangedPaths), mc.NewBytes(mc.MetadataChangedPaths))
+	s += report.bytesSummaryLine("Flagged", len(mc.FlaggedPaths), mc.NewBytes(mc.FlaggedPaths))
+	s += report.rereadSummary()
+	s += report.bytesSummaryLine("Unreadable", len(mc.UnreadablePaths), mc.NewBytes(mc.UnreadablePaths))
+	s += report.bytesSummaryLine("Unstable", len(mc.UnstablePaths), mc.NewBytes(mc.UnstablePaths))
+	s += report.summaryLine("Added link", mc.AddedLinks)
+	s += report.summaryLine("Deleted link", mc.DeletedLinks)
+	s += report.summaryLine("Retargeted link", mc.RetargetedLinks)
 
 	return s
 }
 
+// Summarizes re-reads of flagged paths, if they were re-read.
+func (report *ComparisonReport) rereadSummary() string {
+	mc := report.mc
+	if mc.rereads == nil || len(mc.FlaggedPaths) == 0 {
+		return ""
+	}
+	inconsistent := len(mc.InconsistentPaths())
+	s := fmt.Sprintf("    %d persistently different, %d with inconsistent reads\n", len(mc.FlaggedPaths)-inconsistent, inconsistent)
+	if inconsistent > 0 {
+		s += "    Inconsistent reads point at hardware (cables, controller, disk) rather than stored data.\n"
+	}
+	return s
+}
+
+func (report *ComparisonReport) exclusionWarning() string {
+	added, removed := report.mc.ExclusionChanges()
+	if len(added) == 0 && len(removed) == 0 {
+		return ""
+	}
+	s := "WARNING: manifests were generated with different exclusions; added and deleted paths may be affected.\n"
+	if len(added) > 0 {
+		s += fmt.Sprintf("Newly excluded: %s\n", quotedList(added))
+	}
+	if len(removed) > 0 {
+		s += fmt.Sprintf("No longer excluded: %s\n", quotedList(removed))
+	}
+	return s + "\n"
+}
+
 func (report *ComparisonReport) DetailString() string {
 	return report.unchangedSection() +
 		report.pathSection("Added", report.mc.AddedPaths) +
 		report.pathSection("Deleted", report.mc.DeletedPaths) +
 		report.renamedSection() +
 		report.pathSection("Modified", report.mc.ModifiedPaths) +
-		report.pathSection("Flagged", report.mc.FlaggedPaths)
+		report.metadataChangedSection() +
+		report.flaggedSection() +
+		report.unreadableSection() +
+		report.pathSection("Unstable", report.mc.UnstablePaths) +
+		report.linkSection("Added link", report.mc.AddedLinks) +
+		report.linkSection("Deleted link", report.mc.DeletedLinks) +
+		report.linkSection("Retargeted link", report.mc.RetargetedLinks)
 }
 
 func (report *ComparisonReport) summaryLine(description string, paths []string) string {
@@ -53,6 +101,10 @@ func (report *ComparisonReport) summaryLine(description string, paths []string)
 	return fmt.Sprintf("%s paths: %d\n", description, count)
 }
 
+func (report *ComparisonReport) bytesSummaryLine(description string, count int, bytes int64) string {
+	return fmt.Sprintf("%s paths: %d (%s)\n", description, count, ByteSize(bytes))
+}
+
 func (report *ComparisonReport) pathSection(description string, paths []string) string {
 	s := report.summaryLine(description, paths)
 	for _, path := range paths {
@@ -75,3 +127,81 @@ func (report *ComparisonReport) renamedSection() string {
 	}
 	return s
 }
+
+func (report *ComparisonReport) metadataChangedSection() string {
+	paths := report.mc.MetadataChangedPaths
+	s := report.summaryLine("Metadata changed", paths)
+	for _, path := range paths {
+		s += fmt.Sprintf("    %s (%s)\n", path, report.mc.MetadataChanges(path))
+	}
+	return s
+}
+
+func (report *ComparisonReport) flaggedSection() string {
+	paths := report.mc.FlaggedPaths
+	s := report.summaryLine("Flagged", paths)
+	// Scariest corruption first, for triage
+	corruptions := map[string]Corruption{}
+	for _, path := range paths {
+		corruptions[path] = report.mc.Classify(path)
+	}
+	sorted := append([]string{}, paths...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return corruptionSeverity[corruptions[sorted[i]].Kind] < corruptionSeverity[corruptions[sorted[j]].Kind]
+	})
+	for _, path := range sorted {
+		notes := []string{}
+		if rereads := report.mc.Rereads(path); rereads != nil {
+			notes = append(notes, rereads.String())
+		}
+		if corruption := corruptions[path]; corruption.Known() {
+			notes = append(notes, corruption.String())
+		}
+		if since := report.mc.FlaggedSince(path); since != nil {
+			notes = append(notes, fmt.Sprintf("flagged since %s", since.Local().Format(time.RFC1123)))
+		}
+		if ranges := report.mc.ChangedRanges(path); len(ranges) > 0 {
+			notes = append(notes, describeRanges(ranges))
+		}
+		if len(notes) > 0 {
+			s += fmt.Sprintf("    %s (%s)\n", path, strings.Join(notes, "; "))
+		} else {
+			s += fmt.Sprintf("    %s\n", path)
+		}
+	}
+	return s
+}
+
+func (report *ComparisonReport) unreadableSection() string {
+	paths := report.mc.UnreadablePaths
+	s := report.summaryLine("Unreadable", paths)
+	for _, path := range paths {
+		s += fmt.Sprintf("    %s (%s)\n", path, report.mc.UnreadableReason(path))
+	}
+	return s
+}
+
+// Lists symlinks with their targets, showing both targets where they changed.
+func (report *ComparisonReport) linkSection(description string, paths []string) string {
+	s := report.summaryLine(description, paths)
+	for _, path := range paths {
+		oldTarget, newTarget := report.mc.LinkTargets(path)
+		switch {
+		case newTarget == "":
+			s += fmt.Sprintf("    %s -> %s\n", path, oldTarget)
+		case oldTarget == "" || oldTarget == newTarget:
+			s += fmt.Sprintf("    %s -> %s\n", path, newTarget)
+		default:
+			s += fmt.Sprintf("    %s -> %s (was %s)\n", path, newTarget, oldTarget)
+		}
+	}
+	return s
+}
+
+func quotedList(names []string) string {
+	quoted := []string{}
+	for _, name := range names {
+		quoted = append(quoted, strconv.Quote(name))
+	}
+	return strings.Join(quoted, ", ")
+}
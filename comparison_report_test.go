@@ -1,3 +1,38 @@
 package main
 
-// TODO write unit tests - somewhat covered with integration tests in bitrot_test
+// TODO write more unit tests - somewhat covered with integration tests in bitrot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComparisonReportByteTotals(t *testing.T) {
+	modTime := time.Now()
+	oldManifest := &Manifest{
+		Entries: map[string]ChecksumRecord{
+			"unchanged": {Checksum: "a", ModTime: modTime, Size: 1024, Mode: 0644},
+			"chmodded":  {Checksum: "b", ModTime: modTime, Size: 100, Mode: 0644},
+			"flagged":   {Checksum: "c", ModTime: modTime, Size: 3 << 20, Mode: 0644},
+			"deleted":   {Checksum: "d", ModTime: modTime, Size: 5, Mode: 0644},
+		},
+	}
+	newManifest := &Manifest{
+		Entries: map[string]ChecksumRecord{
+			"unchanged": {Checksum: "a", ModTime: modTime, Size: 1024, Mode: 0644},
+			"chmodded":  {Checksum: "b", ModTime: modTime, Size: 100, Mode: 0755},
+			"flagged":   {Checksum: "x", ModTime: modTime, Size: 3 << 20, Mode: 0644},
+		},
+	}
+	report := NewComparisonReport(CompareManifests(oldManifest, newManifest))
+
+	summary := report.SummaryString()
+	assert.Contains(t, summary, "Unchanged paths: 1 (1.0 KiB)\n")
+	assert.Contains(t, summary, "Deleted paths: 1 (5 B)\n")
+	assert.Contains(t, summary, "Metadata changed paths: 1 (100 B)\n")
+	assert.Contains(t, summary, "Flagged paths: 1 (3.0 MiB)\n")
+
+	assert.Contains(t, report.DetailString(), "Metadata changed paths: 1\n    chmodded (mode -rw-r--r-- -> -rwxr-xr-x)\n")
+}
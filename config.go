@@ -1,15 +1,24 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
 
 	"github.com/mitchellh/go-homedir"
+	"gopkg.in/yaml.v3"
 )
 
 const (
 	configDir        = ".bitrot"
 	configStorageDir = "manifests"
+	configFileName   = "config.yaml"
+	// Environment variable overriding the configuration directory
+	configDirEnv = "BITROT_DIR"
 )
 
 // TODO should ignored files and directories be handled separately?
@@ -34,24 +43,214 @@ var defaultExcludedFiles = []string{
 
 // Config for bitrot checks such as file/folder names to exclude.
 type Config struct {
-	ExcludedFiles   []string
-	Dir             string
+	ExcludedFiles []string
+	Dir           string
+	HashAlgorithm string
+	// Additional algorithms to checksum with, e.g. to migrate from a previous
+	// manifest's algorithm.
+	AltHashAlgorithms []string
+	// Number of files hashed in parallel on each solid-state device
+	Jobs int
+	// Reuse checksums from the latest manifest for files that appear unchanged
+	Quick bool
+	// Hash the files symlinks point to and walk linked directories, rather
+	// than only recording link targets
+	FollowSymlinks bool
+	// Size of chunks to hash separately within each file; zero for none
+	ChunkSize ByteSize
+	// How to avoid reading through the page cache; empty to read normally
+	CacheMode string
+	// Limits on how fast files are read, if any
+	Throttle *Throttle
+	// How long reading a file may go without progress, or a directory may take
+	// to list, before giving up on it; zero to wait indefinitely
+	FileTimeout time.Duration
+	DirTimeout  time.Duration
+	// Save partial results in manifest storage while hashing
+	Checkpoint bool
+	// Continue from the checkpoint of an interrupted run
+	Resume bool
+	// Manifest storage location, if not in Dir
+	StorageDir string
+	// Named roots declared in the config file
+	Roots           map[string]RootConfig
 	manifestStorage *ManifestStorage
 }
 
+// ConfigFile is the format of the YAML config file.
+type ConfigFile struct {
+	StorageDir string                `yaml:"storage_dir"`
+	Roots      map[string]RootConfig `yaml:"roots"`
+}
+
+// RootConfig declares a tracked directory and the settings used for it when
+// the corresponding command line options aren't given.
+type RootConfig struct {
+	Path string `yaml:"path"`
+	// Names to exclude in place of the defaults, until a manifest records
+	// its own
+	Exclude []string `yaml:"exclude"`
+	Hash    string   `yaml:"hash"`
+	Jobs    int      `yaml:"jobs"`
+	Quick   bool     `yaml:"quick"`
+	// See Config.FollowSymlinks
+	FollowSymlinks bool `yaml:"follow_symlinks"`
+	// Parity redundancy percentage; zero for no parity
+	Parity    int      `yaml:"parity"`
+	ChunkSize ByteSize `yaml:"chunk_size"`
+	// Times to re-read flagged files; negative for none
+	Rereads int `yaml:"rereads"`
+	// "direct" or "evict" to avoid reading through the page cache
+	BypassCache string `yaml:"bypass_cache"`
+	// Read rate limit in MiB per second, and limit on files opened per second
+	MaxReadRate       float64 `yaml:"max_read_rate"`
+	MaxFilesPerSecond float64 `yaml:"max_files_per_second"`
+	// "idle" or "best-effort" to lower the I/O priority of runs, on Linux
+	IOPriority string `yaml:"io_priority"`
+	// CPU niceness of runs
+	Nice int `yaml:"nice"`
+	// See Config.FileTimeout
+	FileTimeout time.Duration `yaml:"file_timeout"`
+	DirTimeout  time.Duration `yaml:"dir_timeout"`
+}
+
 func DefaultConfig() *Config {
-	basedir, err := homedir.Dir()
-	if err != nil {
-		basedir, err = os.Getwd()
+	dir := os.Getenv(configDirEnv)
+	if dir == "" {
+		basedir, err := homedir.Dir()
 		if err != nil {
-			// it's drastic but... come on
-			panic(err)
+			basedir, err = os.Getwd()
+			if err != nil {
+				// it's drastic but... come on
+				panic(err)
+			}
 		}
+		dir = filepath.Join(basedir, configDir)
 	}
 	return &Config{
 		ExcludedFiles: defaultExcludedFiles,
-		Dir:           filepath.Join(basedir, configDir),
+		Dir:           dir,
+	}
+}
+
+// LoadConfig returns the default config with settings from the config file at
+// path, or from the default config file if path is empty and there is one.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	if path == "" {
+		path = filepath.Join(config.Dir, configFileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return config, nil
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file ConfigFile
+	err = yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %s", path, err)
+	}
+
+	if file.StorageDir != "" {
+		config.StorageDir, err = expandPath(file.StorageDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	config.Roots = map[string]RootConfig{}
+	for name, root := range file.Roots {
+		if root.Path == "" {
+			return nil, fmt.Errorf("root %q in config file %s has no path", name, path)
+		}
+		if root.Parity != 0 {
+			if err := checkParityRedundancy(root.Parity); err != nil {
+				return nil, fmt.Errorf("root %q in config file %s: %s", name, path, err)
+			}
+		}
+		if err := checkIOPriority(root.IOPriority); err != nil {
+			return nil, fmt.Errorf("root %q in config file %s: %s", name, path, err)
+		}
+		if err := checkCacheMode(root.BypassCache); err != nil {
+			return nil, fmt.Errorf("root %q in config file %s: %s", name, path, err)
+		}
+		if root.Hash != "" {
+			if _, ok := hashAlgorithms[root.Hash]; !ok {
+				return nil, fmt.Errorf("root %q in config file %s has unknown hash algorithm %q", name, path, root.Hash)
+			}
+		}
+		root.Path, err = expandPath(root.Path)
+		if err != nil {
+			return nil, err
+		}
+		config.Roots[name] = root
 	}
+	return config, nil
+}
+
+// RootNames returns the names of configured roots in sorted order.
+func (c *Config) RootNames() []string {
+	names := []string{}
+	for name := range c.Roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rootSettings returns the configured root for a path, or empty settings if
+// the path isn't configured.
+func (c *Config) rootSettings(path string) RootConfig {
+	for _, root := range c.Roots {
+		if root.Path == path {
+			return root
+		}
+	}
+	return RootConfig{}
+}
+
+func expandPath(path string) (string, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+// resolveExclusions sets the names to exclude for a run. Explicit excludes
+// replace those recorded with the baseline manifest (or the defaults, if it
+// has none); additions and removals are then applied.
+func (c *Config) resolveExclusions(baseline *Manifest, exclude, add, remove []string) {
+	base := c.ExcludedFiles
+	if len(exclude) > 0 {
+		base = exclude
+	} else if baseline != nil && baseline.Excludes != nil {
+		base = baseline.Excludes
+	}
+
+	removed := map[string]bool{}
+	for _, name := range remove {
+		removed[name] = true
+	}
+	excludes := []string{}
+	seen := map[string]bool{}
+	for _, name := range append(append([]string{}, base...), add...) {
+		if !removed[name] && !seen[name] {
+			excludes = append(excludes, name)
+			seen[name] = true
+		}
+	}
+	c.ExcludedFiles = excludes
+}
+
+// globalIgnoreFile returns the path of the ignore file applied to every root.
+func (c *Config) globalIgnoreFile() string {
+	if c.Dir == "" {
+		return ""
+	}
+	return filepath.Join(c.Dir, globalIgnoreFileName)
 }
 
 func (c *Config) isIgnoredPath(path string) bool {
@@ -64,9 +263,66 @@ func (c *Config) isIgnoredPath(path string) bool {
 	return false
 }
 
+func (c *Config) hashAlgorithm() string {
+	if c.HashAlgorithm == "" {
+		return defaultHashAlgorithm
+	}
+	return c.HashAlgorithm
+}
+
+func (c *Config) jobs() int {
+	if c.Jobs < 1 {
+		return runtime.NumCPU()
+	}
+	return c.Jobs
+}
+
+func (c *Config) altHashAlgorithms() []string {
+	algorithms := []string{}
+	for _, alt := range c.AltHashAlgorithms {
+		if alt != c.hashAlgorithm() {
+			algorithms = append(algorithms, alt)
+		}
+	}
+	return algorithms
+}
+
+// useBaselineAlgorithm configures hashing so new manifests can be compared to
+// baseline: its algorithm is kept unless another was explicitly chosen, in which
+// case checksums are computed with both during this run.
+func (c *Config) useBaselineAlgorithm(baseline *Manifest) {
+	if baseline == nil {
+		return
+	}
+	if c.HashAlgorithm == "" {
+		c.HashAlgorithm = baseline.HashAlgorithm()
+	} else if c.HashAlgorithm != baseline.HashAlgorithm() {
+		c.AltHashAlgorithms = append(c.AltHashAlgorithms, baseline.HashAlgorithm())
+	}
+	// Files flagged while migrating only have known-good checksums in the old
+	// algorithm, so keep hashing with it until their flags are resolved
+	for _, algorithm := range baseline.flaggedOnlyAlgorithms() {
+		if algorithm != c.HashAlgorithm && !containsString(c.AltHashAlgorithms, algorithm) {
+			c.AltHashAlgorithms = append(c.AltHashAlgorithms, algorithm)
+		}
+	}
+}
+
+// useBaselineChunkSize keeps hashing chunks of the size baseline used, unless a
+// size was explicitly chosen.
+func (c *Config) useBaselineChunkSize(baseline *Manifest) {
+	if baseline != nil && c.ChunkSize == 0 {
+		c.ChunkSize = ByteSize(baseline.ChunkSize)
+	}
+}
+
 func (c *Config) ManifestStorage() *ManifestStorage {
 	if c.manifestStorage == nil {
-		c.manifestStorage = NewManifestStorage(filepath.Join(c.Dir, configStorageDir))
+		storageDir := c.StorageDir
+		if storageDir == "" {
+			storageDir = filepath.Join(c.Dir, configStorageDir)
+		}
+		c.manifestStorage = NewManifestStorage(storageDir)
 	}
 	return c.manifestStorage
 }
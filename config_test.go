@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveExclusions(t *testing.T) {
+	config := &Config{ExcludedFiles: []string{".git", ".DS_Store"}}
+	config.resolveExclusions(nil, nil, []string{"cache"}, []string{".git"})
+	assert.Equal(t, []string{".DS_Store", "cache"}, config.ExcludedFiles)
+
+	// Recorded exclusions replace the defaults
+	baseline := &Manifest{Excludes: []string{"tmp"}}
+	config = &Config{ExcludedFiles: []string{".git"}}
+	config.resolveExclusions(baseline, nil, []string{"tmp", "cache"}, nil)
+	assert.Equal(t, []string{"tmp", "cache"}, config.ExcludedFiles)
+
+	// Explicit exclusions replace recorded ones
+	config.resolveExclusions(baseline, []string{"other"}, nil, nil)
+	assert.Equal(t, []string{"other"}, config.ExcludedFiles)
+
+	// Manifests without recorded exclusions fall back to the defaults
+	config = &Config{ExcludedFiles: []string{".git"}}
+	config.resolveExclusions(&Manifest{}, nil, nil, nil)
+	assert.Equal(t, []string{".git"}, config.ExcludedFiles)
+}
+
+func TestLoadConfig(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(configDir)
+	t.Setenv(configDirEnv, configDir)
+
+	// No config file
+	config, err := LoadConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, configDir, config.Dir)
+	assert.Equal(t, filepath.Join(configDir, configStorageDir), config.ManifestStorage().Path)
+	assert.Empty(t, config.RootNames())
+
+	writeTestFile(t, configDir, configFileName, `
+storage_dir: /srv/bitrot
+roots:
+  photos:
+    path: /volume1/photos
+    exclude: [Thumbs.db]
+    hash: sha256
+    jobs: 2
+  docs:
+    path: /volume1/docs
+    quick: true
+    follow_symlinks: true
+    chunk_size: 1M
+    bypass_cache: direct
+    max_read_rate: 50
+    max_files_per_second: 200
+    io_priority: idle
+    nice: 10
+    file_timeout: 5m
+    dir_timeout: 30s
+`)
+	config, err = LoadConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, "/srv/bitrot", config.ManifestStorage().Path)
+	assert.Equal(t, []string{"docs", "photos"}, config.RootNames())
+	assert.Equal(t, RootConfig{Path: "/volume1/photos", Exclude: []string{"Thumbs.db"}, Hash: "sha256", Jobs: 2}, config.rootSettings("/volume1/photos"))
+	assert.True(t, config.rootSettings("/volume1/docs").Quick)
+	assert.True(t, config.rootSettings("/volume1/docs").FollowSymlinks)
+	assert.Equal(t, ByteSize(1<<20), config.rootSettings("/volume1/docs").ChunkSize)
+	assert.Equal(t, cacheModeDirect, config.rootSettings("/volume1/docs").BypassCache)
+	assert.Equal(t, 50.0, config.rootSettings("/volume1/docs").MaxReadRate)
+	assert.Equal(t, 200.0, config.rootSettings("/volume1/docs").MaxFilesPerSecond)
+	assert.Equal(t, ioPriorityIdle, config.rootSettings("/volume1/docs").IOPriority)
+	assert.Equal(t, 10, config.rootSettings("/volume1/docs").Nice)
+	assert.Equal(t, 5*time.Minute, config.rootSettings("/volume1/docs").FileTimeout)
+	assert.Equal(t, 30*time.Second, config.rootSettings("/volume1/docs").DirTimeout)
+	assert.Equal(t, RootConfig{}, config.rootSettings("/elsewhere"))
+
+	// Explicit config file
+	otherFile := writeTestFile(t, configDir, "other.yaml", "roots:\n  bad:\n    path: /x\n    hash: md5\n")
+	_, err = LoadConfig(otherFile)
+	assert.EqualError(t, err, `root "bad" in config file `+otherFile+` has unknown hash algorithm "md5"`)
+
+	writeTestFile(t, configDir, "other.yaml", "roots:\n  bad:\n    path: /x\n    bypass_cache: always\n")
+	_, err = LoadConfig(otherFile)
+	assert.EqualError(t, err, `root "bad" in config file `+otherFile+`: cache bypass must be "direct" or "evict", not "always"`)
+
+	writeTestFile(t, configDir, "other.yaml", "roots:\n  bad:\n    path: /x\n    io_priority: realtime\n")
+	_, err = LoadConfig(otherFile)
+	assert.EqualError(t, err, `root "bad" in config file `+otherFile+`: I/O priority must be "idle" or "best-effort", not "realtime"`)
+
+	_, err = LoadConfig(filepath.Join(configDir, "missing.yaml"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// isRotationalDevice reports whether the kernel considers the block device to
+// be a spinning disk. Devices without a block device entry (network and
+// virtual filesystems) are not.
+func isRotationalDevice(device uint64) bool {
+	sysPath, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(device), unix.Minor(device)))
+	if err != nil {
+		return false
+	}
+	// Partitions don't have a queue of their own; check the parent disk
+	for _, dir := range []string{sysPath, filepath.Dir(sysPath)} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "queue", "rotational"))
+		if err == nil {
+			return strings.TrimSpace(string(data)) == "1"
+		}
+	}
+	return false
+}
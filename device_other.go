@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+// isRotationalDevice reports whether the device is a spinning disk. There is
+// no portable way to tell on this platform, so devices are assumed to handle
+// parallel reads well.
+func isRotationalDevice(device uint64) bool {
+	return false
+}
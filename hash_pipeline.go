@@ -0,0 +1,472 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Files queued per worker before the walk waits for hashing to catch up
+const queueDepthPerWorker = 4
+
+// How often partial results are checkpointed
+const checkpointInterval = time.Minute
+
+// Exit status when a second interrupt forces a run to quit, as for SIGINT
+const exitInterrupted = 130
+
+var errPipelineStopped = errors.New("hashing stopped")
+
+var errInterrupted = errors.New("interrupted; run again with --resume to continue")
+
+type hashJob struct {
+	path    string
+	relPath string
+	info    os.FileInfo
+	// Target of the symlink the file was found through, if any
+	linkTarget string
+}
+
+type hashResult struct {
+	relPath string
+	record  ChecksumRecord
+}
+
+// deviceState is shared by the workers reading a device.
+type deviceState struct {
+	mutex sync.Mutex
+	// Closed when a read given up on as stalled finally returns, or nil if
+	// there's none outstanding
+	stuck <-chan struct{}
+	// Reason later files aren't read while the stalled read is outstanding
+	stuckErr error
+}
+
+// stalled returns an error while a read on the device that was given up on
+// hasn't returned, since other reads would most likely hang too.
+func (d *deviceState) stalled() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stuck == nil {
+		return nil
+	}
+	select {
+	case <-d.stuck:
+		d.stuck = nil
+		return nil
+	default:
+		return d.stuckErr
+	}
+}
+
+func (d *deviceState) setStalled(stuck <-chan struct{}, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.stuck = stuck
+	d.stuckErr = err
+}
+
+// hashPipeline walks a directory and hashes its files concurrently. Each
+// device gets its own queue and pool of workers, so that a spinning disk is
+// read one file at a time while solid-state devices are read in parallel.
+type hashPipeline struct {
+	root       string
+	config     *Config
+	algorithms []string
+	// Previous manifest whose records are reused for unchanged files
+	known *Manifest
+	// Checkpoint of an interrupted run whose records are reused for
+	// unchanged files
+	resumed *Manifest
+	// Saves partial results periodically and when interrupted, if set
+	checkpoint  func(map[string]ChecksumRecord) error
+	interrupted bool
+	// Directories that couldn't be read, by relative path, with the reason.
+	// Only used by the walk goroutine until the run finishes.
+	unreadableDirs map[string]string
+	// When following symlinks: the path each file was first found at and
+	// other paths to the same files, and the directories being walked, to
+	// detect loops. Only used by the walk goroutine until the run finishes.
+	realFiles   map[fileID]string
+	aliases     map[string]fileAlias
+	walkingDirs map[fileID]bool
+	ignores     *ignoreMatcher
+	queues      map[uint64]chan hashJob
+	results     chan hashResult
+	done        chan struct{}
+	stopOnce    sync.Once
+	workers     sync.WaitGroup
+}
+
+func newHashPipeline(root string, config *Config, algorithms []string) *hashPipeline {
+	return &hashPipeline{
+		root:           root,
+		config:         config,
+		algorithms:     algorithms,
+		queues:         map[uint64]chan hashJob{},
+		unreadableDirs: map[string]string{},
+		realFiles:      map[fileID]string{},
+		aliases:        map[string]fileAlias{},
+		walkingDirs:    map[fileID]bool{},
+		results:        make(chan hashResult),
+		done:           make(chan struct{}),
+	}
+}
+
+// run returns records keyed by normalized relative path, or the first error
+// encountered.
+func (p *hashPipeline) run() (map[string]ChecksumRecord, error) {
+	var err error
+	p.ignores, err = newIgnoreMatcher(p.root, p.config)
+	if err != nil {
+		return nil, err
+	}
+
+	walkErr := make(chan error, 1)
+	go func() {
+		err := p.walk()
+		if err != nil {
+			p.stop()
+		}
+		for _, queue := range p.queues {
+			close(queue)
+		}
+		p.workers.Wait()
+		close(p.results)
+		walkErr <- err
+	}()
+
+	var ticker <-chan time.Time
+	var signals chan os.Signal
+	if p.checkpoint != nil {
+		checkpointTicker := time.NewTicker(checkpointInterval)
+		defer checkpointTicker.Stop()
+		ticker = checkpointTicker.C
+		signals = make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+	}
+
+	records := map[string]ChecksumRecord{}
+collect:
+	for {
+		select {
+		case result, ok := <-p.results:
+			if !ok {
+				break collect
+			}
+			records[result.relPath] = result.record
+		case <-ticker:
+			if e := p.checkpoint(records); e != nil && err == nil {
+				err = e
+				p.stop()
+			}
+		case <-signals:
+			if p.interrupted {
+				// Quit without waiting for reads that may never finish
+				os.Exit(exitInterrupted)
+			}
+			p.interrupt()
+		}
+	}
+	if e := <-walkErr; err == nil && e != errPipelineStopped {
+		err = e
+	}
+	if p.interrupted && err == nil {
+		// Files hashed before the interruption are kept for --resume
+		err = p.checkpoint(records)
+		if err == nil {
+			err = errInterrupted
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Files found at more than one path were only hashed once
+	for relPath, alias := range p.aliases {
+		record := records[alias.path]
+		record.LinkTarget = alias.linkTarget
+		records[relPath] = record
+	}
+	return records, nil
+}
+
+// interrupt stops hashing, keeping results so far in a checkpoint. Only
+// called from the goroutine running the pipeline.
+func (p *hashPipeline) interrupt() {
+	p.interrupted = true
+	p.stop()
+}
+
+func (p *hashPipeline) stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
+
+// walk calls visit for the root and everything under it in lexical order, as
+// filepath.Walk does, but gives up on directories that can't be read within
+// the directory timeout.
+func (p *hashPipeline) walk() error {
+	// The root is walked even if it's a symlink
+	info, err := os.Stat(p.root)
+	if err != nil {
+		return p.visit(p.root, nil, err)
+	}
+	return p.walkEntry(p.root, info)
+}
+
+func (p *hashPipeline) walkEntry(entryPath string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return p.walkLink(entryPath, info)
+	}
+	err := p.visit(entryPath, info, nil)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	if err != nil || !info.IsDir() {
+		return err
+	}
+	return p.walkDir(entryPath, info)
+}
+
+func (p *hashPipeline) walkDir(dirPath string, info os.FileInfo) error {
+	if p.config.FollowSymlinks {
+		id := fileIDFromInfo(info)
+		p.walkingDirs[id] = true
+		defer delete(p.walkingDirs, id)
+	}
+	entries, err := readDirEntries(dirPath, p.config.DirTimeout)
+	if err != nil {
+		return p.visit(dirPath, info, err)
+	}
+	for _, entry := range entries {
+		childPath := filepath.Join(dirPath, entry.name)
+		if entry.err != nil {
+			err = p.visit(childPath, nil, entry.err)
+		} else {
+			err = p.walkEntry(childPath, entry.info)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkLink records a symlink with its target. When following symlinks, a link
+// to a file is hashed as that file, and a link to a directory is also walked
+// unless it would loop back to a directory already being walked.
+func (p *hashPipeline) walkLink(entryPath string, info os.FileInfo) error {
+	relPath, err := filepath.Rel(p.root, entryPath)
+	if err != nil {
+		return err
+	}
+	var targetInfo os.FileInfo
+	if p.config.FollowSymlinks {
+		// Broken links are recorded as links
+		targetInfo, _ = os.Stat(entryPath)
+	}
+	isDir := targetInfo != nil && targetInfo.IsDir()
+	if p.isIgnored(entryPath, relPath, isDir) {
+		return nil
+	}
+
+	relPath = norm.NFC.String(relPath)
+	target, err := os.Readlink(entryPath)
+	if err != nil {
+		return p.send(hashResult{relPath: relPath, record: unreadableRecord(info, err)})
+	}
+	if targetInfo != nil && targetInfo.Mode().IsRegular() {
+		return p.queueFile(entryPath, relPath, targetInfo, target)
+	}
+	err = p.send(hashResult{relPath: relPath, record: linkRecord(info, target)})
+	if err != nil || !isDir {
+		return err
+	}
+	// Loops can't be detected without inodes
+	if id := fileIDFromInfo(targetInfo); !id.known() || p.walkingDirs[id] {
+		return nil
+	}
+	return p.walkEntry(entryPath, targetInfo)
+}
+
+// isIgnored reports whether an entry is excluded or matches an ignore rule.
+func (p *hashPipeline) isIgnored(entryPath, relPath string, isDir bool) bool {
+	return p.config.isIgnoredPath(entryPath) || p.ignores.ignores(relPath, isDir)
+}
+
+func (p *hashPipeline) visit(entryPath string, info os.FileInfo, err error) error {
+	// Without the root there's nothing to record
+	if err != nil && entryPath == p.root {
+		return err
+	}
+
+	relPath, relErr := filepath.Rel(p.root, entryPath)
+	if relErr != nil {
+		return relErr
+	}
+	isDir := info != nil && info.IsDir()
+	if p.isIgnored(entryPath, relPath, isDir) {
+		if isDir && err == nil {
+			// Skip walking this directory
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	if err != nil {
+		// Keep walking; the entry is recorded as unreadable
+		if isDir {
+			p.unreadableDirs[norm.NFC.String(relPath)] = describeReadError(err)
+			return nil
+		}
+		return p.send(hashResult{relPath: norm.NFC.String(relPath), record: unreadableRecord(info, err)})
+	}
+
+	if info.IsDir() {
+		err = p.ignores.loadDir(relPath, p.config.DirTimeout)
+		if err != nil && entryPath != p.root {
+			// Its contents can't be told apart from ignored files, so the
+			// directory is treated as unreadable
+			p.unreadableDirs[norm.NFC.String(relPath)] = describeReadError(err)
+			return filepath.SkipDir
+		}
+		return err
+	}
+
+	if info.Mode().IsRegular() {
+		// Normalize Unicode combining characters
+		return p.queueFile(entryPath, norm.NFC.String(relPath), info, "")
+	}
+
+	return nil
+}
+
+// queueFile queues a file for hashing, found through a symlink to linkTarget if
+// that's set, unless its record can be reused. When following symlinks, a file
+// already found at another path is recorded as an alias rather than hashed
+// again.
+func (p *hashPipeline) queueFile(entryPath, relPath string, info os.FileInfo, linkTarget string) error {
+	if p.config.FollowSymlinks {
+		if id := fileIDFromInfo(info); id.known() {
+			if firstPath, ok := p.realFiles[id]; ok {
+				p.aliases[relPath] = fileAlias{path: firstPath, linkTarget: linkTarget}
+				return nil
+			}
+			p.realFiles[id] = relPath
+		}
+	}
+	if record, ok := p.reusableRecord(relPath, info); ok {
+		record.LinkTarget = linkTarget
+		return p.send(hashResult{relPath: relPath, record: record})
+	}
+	select {
+	case p.queueFor(info) <- hashJob{path: entryPath, relPath: relPath, info: info, linkTarget: linkTarget}:
+		return nil
+	case <-p.done:
+		return errPipelineStopped
+	}
+}
+
+// send passes a result from the walk to the collector.
+func (p *hashPipeline) send(result hashResult) error {
+	select {
+	case p.results <- result:
+		return nil
+	case <-p.done:
+		return errPipelineStopped
+	}
+}
+
+// queueFor returns the queue for the file's device, starting workers for the
+// device the first time it is seen. Only called from the walk goroutine.
+func (p *hashPipeline) queueFor(info os.FileInfo) chan hashJob {
+	device := statFromInfo(info).Device
+	queue, ok := p.queues[device]
+	if ok {
+		return queue
+	}
+
+	workers := p.config.jobs()
+	if isRotationalDevice(device) {
+		workers = 1
+	}
+	queue = make(chan hashJob, workers*queueDepthPerWorker)
+	p.queues[device] = queue
+	state := &deviceState{}
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.work(queue, state)
+	}
+	return queue
+}
+
+func (p *hashPipeline) work(queue chan hashJob, device *deviceState) {
+	defer p.workers.Done()
+	for job := range queue {
+		select {
+		case <-p.done:
+			// Drain the queue without hashing
+			continue
+		default:
+		}
+		var record ChecksumRecord
+		if err := device.stalled(); err != nil {
+			// Fail fast rather than leave another read hanging
+			record = unreadableRecord(job.info, err)
+		} else {
+			record = p.hash(job, device)
+		}
+		record.LinkTarget = job.linkTarget
+		p.results <- hashResult{relPath: job.relPath, record: record}
+	}
+}
+
+// hash hashes a queued file, giving up if reading it stalls for the file
+// timeout. Files that can't be read are recorded as unreadable rather than
+// stopping the run.
+func (p *hashPipeline) hash(job hashJob, device *deviceState) ChecksumRecord {
+	watch := newStallWatch()
+	hashed := make(chan ChecksumRecord, 1)
+	finished := make(chan struct{})
+	err := withStallTimeout(p.config.FileTimeout, watch, func() error {
+		defer close(finished)
+		record, err := hashStableFile(job.path, p.algorithms, hashOptions{
+			chunkSize: int64(p.config.ChunkSize),
+			cacheMode: p.config.CacheMode,
+			throttle:  p.config.Throttle,
+			watch:     watch,
+		})
+		hashed <- record
+		return err
+	})
+	if err != nil {
+		select {
+		case <-finished:
+		default:
+			// Abandoned while still reading
+			device.setStalled(finished, fmt.Errorf("device stalled reading %s", job.relPath))
+		}
+		return unreadableRecord(job.info, err)
+	}
+	return <-hashed
+}
+
+// reusableRecord returns a record for an unchanged file from an interrupted
+// run or, in quick mode, from the previous manifest.
+func (p *hashPipeline) reusableRecord(relPath string, info os.FileInfo) (ChecksumRecord, bool) {
+	chunkSize := int64(p.config.ChunkSize)
+	if record, ok := carryForward(p.resumed, relPath, info, p.algorithms, chunkSize); ok {
+		// Hashed by the interrupted run, so only carried forward if it was
+		// then
+		record.CarriedForward = p.resumed.Entries[relPath].CarriedForward
+		return record, true
+	}
+	return carryForward(p.known, relPath, info, p.algorithms, chunkSize)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashPipelineMatchesSerialRun(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+
+	defer os.RemoveAll(tempDir)
+
+	for i := 0; i < 10; i++ {
+		subdir := filepath.Join(tempDir, fmt.Sprintf("dir%d", i))
+		assert.Nil(t, os.MkdirAll(subdir, 0755))
+		for j := 0; j < 20; j++ {
+			writeTestFile(t, subdir, fmt.Sprintf("file%d", j), fmt.Sprintf("content %d %d", i, j))
+		}
+	}
+
+	algorithms := []string{defaultHashAlgorithm}
+	serial, err := newHashPipeline(tempDir, &Config{Jobs: 1}, algorithms).run()
+	assert.Nil(t, err)
+	assert.Len(t, serial, 200)
+
+	pipeline := newHashPipeline(tempDir, &Config{Jobs: 8}, algorithms)
+	parallel, err := pipeline.run()
+	assert.Nil(t, err)
+	assert.Equal(t, serial, parallel)
+	// Everything is on one device
+	assert.Len(t, pipeline.queues, 1)
+}
+
+func TestHashPipelineError(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+
+	defer os.RemoveAll(tempDir)
+
+	records, err := newHashPipeline(filepath.Join(tempDir, "missing"), &Config{}, []string{defaultHashAlgorithm}).run()
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, records)
+}
+
+func TestHashPipelineInterrupt(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+
+	defer os.RemoveAll(tempDir)
+
+	populateTestDirectory(t, tempDir)
+	var checkpointed map[string]ChecksumRecord
+	pipeline := newHashPipeline(tempDir, &Config{}, []string{defaultHashAlgorithm})
+	pipeline.checkpoint = func(records map[string]ChecksumRecord) error {
+		checkpointed = records
+		return nil
+	}
+	pipeline.interrupt()
+	records, err := pipeline.run()
+	assert.Equal(t, errInterrupted, err)
+	assert.Nil(t, records)
+	assert.NotNil(t, checkpointed)
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	configDir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(configDir)
+
+	populateTestDirectory(t, tempDir)
+	config := &Config{Dir: configDir}
+	full, err := NewManifest(tempDir, config)
+	assert.Nil(t, err)
+
+	// Checkpoint with one file done, doctored to show it isn't re-hashed
+	record := full.Entries["foo"]
+	record.Checksum = "from checkpoint"
+	checkpoint := &Manifest{
+		Path:      tempDir,
+		Algorithm: full.Algorithm,
+		Entries:   map[string]ChecksumRecord{"foo": record},
+	}
+	storage := config.ManifestStorage()
+	assert.Nil(t, storage.SaveCheckpoint(checkpoint))
+
+	config.Resume = true
+	resumed, err := NewManifest(tempDir, config)
+	assert.Nil(t, err)
+	assert.Equal(t, "from checkpoint", resumed.Entries["foo"].Checksum)
+	assert.False(t, resumed.Entries["foo"].CarriedForward)
+	assert.Equal(t, full.Entries["bar/baz/stuff/foo"].Checksum, resumed.Entries["bar/baz/stuff/foo"].Checksum)
+	assert.Len(t, resumed.Entries, len(full.Entries))
+}
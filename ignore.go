@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ignoreFileName       = ".bitrotignore"
+	globalIgnoreFileName = "ignore"
+)
+
+// ignoreRule is one pattern from an ignore file, with gitignore semantics.
+type ignoreRule struct {
+	Pattern string
+	Source  string
+	Line    int
+	// Re-includes paths matched by earlier rules
+	Negate  bool
+	dirOnly bool
+	// Directory (slash-separated, relative to the root) the rule applies
+	// within; empty for the root
+	base   string
+	regexp *regexp.Regexp
+}
+
+func (r *ignoreRule) String() string {
+	return fmt.Sprintf("%s:%d:%s", r.Source, r.Line, r.Pattern)
+}
+
+func (r *ignoreRule) matches(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(relPath, r.base+"/") {
+			return false
+		}
+		relPath = relPath[len(r.base)+1:]
+	}
+	return r.regexp.MatchString(relPath)
+}
+
+// parseIgnoreRule parses a line of an ignore file, returning nil for blank
+// lines and comments.
+func parseIgnoreRule(line string) *ignoreRule {
+	pattern := strings.TrimRight(line, "\r")
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(pattern, " ") && !strings.HasSuffix(pattern, "\\ ") {
+		pattern = pattern[:len(pattern)-1]
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+
+	rule := &ignoreRule{Pattern: pattern}
+	if strings.HasPrefix(pattern, "!") {
+		rule.Negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil
+	}
+
+	// Patterns containing a slash are relative to the ignore file's directory;
+	// others match a name at any depth
+	prefix := "^(?:.*/)?"
+	if strings.Contains(pattern, "/") {
+		prefix = "^"
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	rule.regexp = regexp.MustCompile(prefix + globToRegexp(pattern) + "$")
+	return rule
+}
+
+// globToRegexp translates gitignore glob syntax: "*" and "?" don't match
+// slashes, "**" between slashes matches any number of directories, and
+// brackets are character classes.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**") &&
+				(i == 0 || pattern[i-1] == '/') &&
+				(i+2 == len(pattern) || pattern[i+2] == '/') {
+				if i+2 == len(pattern) {
+					b.WriteString(".*")
+				} else {
+					b.WriteString("(?:.*/)?")
+				}
+				// Skip the second "*" and any following slash
+				i += 2
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			class, length := bracketClass(pattern[i:])
+			if length == 0 {
+				b.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			b.WriteString(class)
+			i += length - 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Translates a bracket expression at the start of s, returning the regexp
+// class and the length consumed, or zero length if it isn't terminated.
+func bracketClass(s string) (string, int) {
+	i := 1
+	class := "["
+	if i < len(s) && (s[i] == '!' || s[i] == '^') {
+		class += "^"
+		i++
+	}
+	// A leading "]" is literal
+	if i < len(s) && s[i] == ']' {
+		class += "\\]"
+		i++
+	}
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case ']':
+			return class + "]", i + 1
+		case '\\', '[':
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			class += regexp.QuoteMeta(string(s[i]))
+		default:
+			class += string(s[i])
+		}
+	}
+	return "", 0
+}
+
+func readIgnoreFile(filename, base string) ([]*ignoreRule, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := []*ignoreRule{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		rule := parseIgnoreRule(scanner.Text())
+		if rule == nil {
+			continue
+		}
+		rule.Source = filename
+		rule.Line = line
+		rule.base = base
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ignoreMatcher applies the global ignore file and the .bitrotignore files in
+// each directory under a root. As with gitignore, later rules override earlier
+// ones and files deeper in the tree override those above them.
+type ignoreMatcher struct {
+	root   string
+	global []*ignoreRule
+	// Rules from each directory's ignore file, keyed by slash-separated path
+	// relative to the root
+	dirs map[string][]*ignoreRule
+}
+
+func newIgnoreMatcher(root string, config *Config) (*ignoreMatcher, error) {
+	m := &ignoreMatcher{root: root, dirs: map[string][]*ignoreRule{}}
+	if globalFile := config.globalIgnoreFile(); globalFile != "" {
+		rules, err := readIgnoreFile(globalFile, "")
+		if err != nil {
+			return nil, err
+		}
+		m.global = rules
+	}
+	return m, nil
+}
+
+// loadDir reads the ignore file in a directory, given relative to the root,
+// giving up after timeout if nonzero. Must be called for a directory before
+// matching paths inside it.
+func (m *ignoreMatcher) loadDir(relDir string, timeout time.Duration) error {
+	relDir = slashRelPath(relDir)
+	if _, ok := m.dirs[relDir]; ok {
+		return nil
+	}
+	filename := filepath.Join(m.root, filepath.FromSlash(relDir), ignoreFileName)
+	results := make(chan []*ignoreRule, 1)
+	err := withDeadline(timeout, func() error {
+		rules, err := readIgnoreFile(filename, relDir)
+		results <- rules
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	m.dirs[relDir] = <-results
+	return nil
+}
+
+// match returns the rule deciding whether a path relative to the root is
+// ignored, or nil if no rule matches. The path is ignored unless the rule is
+// negated.
+func (m *ignoreMatcher) match(relPath string, isDir bool) *ignoreRule {
+	relPath = slashRelPath(relPath)
+	if relPath == "" {
+		return nil
+	}
+	var matched *ignoreRule
+	rulesets := [][]*ignoreRule{m.global, m.dirs[""]}
+	for _, dir := range ancestorDirs(relPath) {
+		rulesets = append(rulesets, m.dirs[dir])
+	}
+	for _, rules := range rulesets {
+		for _, rule := range rules {
+			if rule.matches(relPath, isDir) {
+				matched = rule
+			}
+		}
+	}
+	return matched
+}
+
+func (m *ignoreMatcher) ignores(relPath string, isDir bool) bool {
+	rule := m.match(relPath, isDir)
+	return rule != nil && !rule.Negate
+}
+
+// Directories containing a slash-separated relative path, outermost first,
+// excluding the root.
+func ancestorDirs(relPath string) []string {
+	dirs := []string{}
+	for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	return dirs
+}
+
+func slashRelPath(relPath string) string {
+	relPath = filepath.ToSlash(relPath)
+	if relPath == "." {
+		return ""
+	}
+	return relPath
+}
+
+// explainIgnore describes whether a path under root is excluded, and by what.
+func explainIgnore(root, entryPath string, config *Config) (string, error) {
+	relPath, err := filepath.Rel(root, entryPath)
+	if err != nil {
+		return "", err
+	}
+	relPath = slashRelPath(relPath)
+	if relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return "", fmt.Errorf("%s is not under %s", entryPath, root)
+	}
+
+	matcher, err := newIgnoreMatcher(root, config)
+	if err != nil {
+		return "", err
+	}
+	if err = matcher.loadDir("", 0); err != nil {
+		return "", err
+	}
+	// Check each directory on the way down, as a walk would
+	for _, dir := range ancestorDirs(relPath) {
+		if config.isIgnoredPath(dir) {
+			return fmt.Sprintf("%s is in directory %s, excluded by name", entryPath, dir), nil
+		}
+		if rule := matcher.match(dir, true); rule != nil && !rule.Negate {
+			return fmt.Sprintf("%s is in directory %s, ignored by %s", entryPath, dir, rule), nil
+		}
+		if err = matcher.loadDir(dir, 0); err != nil {
+			return "", err
+		}
+	}
+
+	if config.isIgnoredPath(entryPath) {
+		return fmt.Sprintf("%s is excluded by name %q", entryPath, filepath.Base(entryPath)), nil
+	}
+	info, err := os.Lstat(entryPath)
+	isDir := err == nil && info.IsDir()
+	rule := matcher.match(relPath, isDir)
+	if rule == nil {
+		return fmt.Sprintf("%s is not ignored", entryPath), nil
+	}
+	if rule.Negate {
+		return fmt.Sprintf("%s is not ignored (re-included by %s)", entryPath, rule), nil
+	}
+	return fmt.Sprintf("%s is ignored by %s", entryPath, rule), nil
+}
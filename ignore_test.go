@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRuleMatching(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		matches bool
+	}{
+		{"*.tmp", "foo.tmp", false, true},
+		{"*.tmp", "a/b/foo.tmp", false, true},
+		{"*.tmp", "foo.tmp/bar", false, false},
+		{"foo?.txt", "foo1.txt", false, true},
+		{"foo?.txt", "foo/.txt", false, false},
+		{"[ab].txt", "b.txt", false, true},
+		{"[!ab].txt", "b.txt", false, false},
+		{"[!ab].txt", "c.txt", false, true},
+		{"Photos/Thumbnails", "Photos/Thumbnails", true, true},
+		{"Photos/Thumbnails", "x/Photos/Thumbnails", true, false},
+		{"/foo", "foo", false, true},
+		{"/foo", "a/foo", false, false},
+		{"cache/**", "cache/a/b", false, true},
+		{"cache/**", "cache", true, false},
+		{"**/logs", "a/b/logs", true, true},
+		{"**/logs", "logs", true, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "src/build", true, true},
+		{`\#hash`, "#hash", false, true},
+		{`\!bang`, "!bang", false, true},
+		{"trailing   ", "trailing", false, true},
+		{"[unterminated", "[unterminated", false, true},
+	}
+	for _, c := range cases {
+		rule := parseIgnoreRule(c.pattern)
+		if assert.NotNil(t, rule, c.pattern) {
+			assert.Equal(t, c.matches, rule.matches(c.path, c.isDir), "%s ~ %s", c.pattern, c.path)
+		}
+	}
+
+	assert.Nil(t, parseIgnoreRule(""))
+	assert.Nil(t, parseIgnoreRule("# comment"))
+	assert.True(t, parseIgnoreRule("!keep").Negate)
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	configDir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(configDir)
+
+	writeTestFile(t, configDir, globalIgnoreFileName, "*.bak\n")
+	writeTestFile(t, tempDir, ignoreFileName, "*.tmp\ncache/**\n!cache/keep\n")
+	assert.Nil(t, os.MkdirAll(filepath.Join(tempDir, "sub"), 0755))
+	writeTestFile(t, filepath.Join(tempDir, "sub"), ignoreFileName, "!important.tmp\n/local\n")
+
+	matcher, err := newIgnoreMatcher(tempDir, &Config{Dir: configDir})
+	assert.Nil(t, err)
+	assert.Nil(t, matcher.loadDir(".", 0))
+	assert.Nil(t, matcher.loadDir("sub", time.Minute))
+
+	assert.True(t, matcher.ignores("foo.bak", false))
+	assert.True(t, matcher.ignores("foo.tmp", false))
+	assert.True(t, matcher.ignores("sub/foo.tmp", false))
+	assert.False(t, matcher.ignores("sub/important.tmp", false))
+	assert.True(t, matcher.ignores("important.tmp", false))
+	assert.True(t, matcher.ignores("sub/local", false))
+	assert.False(t, matcher.ignores("local", false))
+	assert.True(t, matcher.ignores("cache/junk", false))
+	assert.False(t, matcher.ignores("cache/keep", false))
+	assert.False(t, matcher.ignores("cache", true))
+
+	rule := matcher.match("sub/important.tmp", false)
+	assert.Equal(t, filepath.Join(tempDir, "sub", ignoreFileName)+":1:!important.tmp", rule.String())
+}
+
+func TestManifestHonorsIgnoreFiles(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	populateTestDirectory(t, tempDir)
+	writeTestFile(t, tempDir, ignoreFileName, "bar/\n")
+	writeTestFile(t, tempDir, "scratch.tmp", "scratch")
+	assert.Nil(t, os.MkdirAll(filepath.Join(tempDir, "other"), 0755))
+	writeTestFile(t, filepath.Join(tempDir, "other"), ignoreFileName, "*.tmp\n")
+	writeTestFile(t, filepath.Join(tempDir, "other"), "scratch.tmp", "scratch")
+
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+
+	entryPaths := []string{}
+	for path := range manifest.Entries {
+		entryPaths = append(entryPaths, path)
+	}
+	assert.ElementsMatch(t, []string{"foo", ignoreFileName, "scratch.tmp", "other/" + ignoreFileName}, entryPaths)
+}
+
+func TestExplainIgnore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, tempDir, ignoreFileName, "cache/\n*.tmp\n!keep.tmp\n")
+	config := &Config{ExcludedFiles: []string{".git"}}
+	ignoreFile := filepath.Join(tempDir, ignoreFileName)
+
+	explain := func(relPath string) string {
+		explanation, err := explainIgnore(tempDir, filepath.Join(tempDir, relPath), config)
+		assert.Nil(t, err)
+		return explanation
+	}
+	assert.Equal(t, filepath.Join(tempDir, "a.tmp")+" is ignored by "+ignoreFile+":2:*.tmp", explain("a.tmp"))
+	assert.Equal(t, filepath.Join(tempDir, "keep.tmp")+" is not ignored (re-included by "+ignoreFile+":3:!keep.tmp)", explain("keep.tmp"))
+	assert.Equal(t, filepath.Join(tempDir, "cache/x/y")+" is in directory cache, ignored by "+ignoreFile+":1:cache/", explain("cache/x/y"))
+	assert.Equal(t, filepath.Join(tempDir, ".git/config")+" is in directory .git, excluded by name", explain(".git/config"))
+	assert.Equal(t, filepath.Join(tempDir, "a.txt")+" is not ignored", explain("a.txt"))
+
+	_, err = explainIgnore(tempDir, filepath.Dir(tempDir), config)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// How an incident was closed
+const (
+	// Content matches the known-good checksum again
+	resolutionRepaired = "repaired"
+	// Current content was accepted as the new baseline
+	resolutionAccepted = "accepted"
+	// Content changed along with the modification time
+	resolutionModified = "modified"
+	resolutionDeleted  = "deleted"
+	resolutionRenamed  = "renamed"
+)
+
+// Incident tracks a file flagged for possible corruption, from the first run
+// that flagged it until it is no longer flagged.
+type Incident struct {
+	Path string `json:"path"`
+	// Checksum from before the file was flagged, and the latest differing one,
+	// in the algorithm of the manifest the incident was opened against
+	ExpectedChecksum string    `json:"expected_checksum"`
+	ActualChecksum   string    `json:"actual_checksum"`
+	OpenedAt         time.Time `json:"opened_at"`
+	LastFlaggedAt    time.Time `json:"last_flagged_at"`
+	// Number of runs that flagged the file
+	TimesFlagged int        `json:"times_flagged"`
+	ClosedAt     *time.Time `json:"closed_at,omitempty"`
+	Resolution   string     `json:"resolution,omitempty"`
+}
+
+func (i *Incident) Open() bool {
+	return i.ClosedAt == nil
+}
+
+func (i *Incident) close(at time.Time, resolution string) {
+	i.ClosedAt = &at
+	i.Resolution = resolution
+}
+
+// updateIncidents opens incidents for newly flagged paths, counts another
+// flagging for paths already open, and closes incidents for paths the
+// comparison found no longer flagged. Paths the comparison didn't check (as in
+// a partial scrub) are left as they are.
+func updateIncidents(incidents []*Incident, comparison *ManifestComparison, at time.Time) []*Incident {
+	open := openIncidentsByPath(incidents)
+	for _, path := range comparison.FlaggedPaths {
+		incident, ok := open[path]
+		if !ok {
+			incident = &Incident{
+				Path:             path,
+				ExpectedChecksum: comparison.oldManifest.Entries[path].Checksum,
+				OpenedAt:         at,
+			}
+			incidents = append(incidents, incident)
+		}
+		incident.ActualChecksum = comparison.newManifest.Entries[path].Checksum
+		incident.LastFlaggedAt = at
+		incident.TimesFlagged++
+	}
+
+	resolutions := map[string]string{}
+	for _, path := range append(comparison.UnchangedPaths, comparison.MetadataChangedPaths...) {
+		resolutions[path] = resolutionRepaired
+	}
+	for _, path := range comparison.ModifiedPaths {
+		resolutions[path] = resolutionModified
+	}
+	for _, path := range comparison.DeletedPaths {
+		resolutions[path] = resolutionDeleted
+	}
+	for _, renamed := range comparison.RenamedPaths {
+		resolutions[renamed.OldPath] = resolutionRenamed
+	}
+	for path, resolution := range resolutions {
+		if incident, ok := open[path]; ok {
+			incident.close(at, resolution)
+		}
+	}
+	return incidents
+}
+
+// closeIncidents closes any open incidents for the paths.
+func closeIncidents(incidents []*Incident, paths []string, at time.Time, resolution string) {
+	open := openIncidentsByPath(incidents)
+	for _, path := range paths {
+		if incident, ok := open[path]; ok {
+			incident.close(at, resolution)
+		}
+	}
+}
+
+func openIncidentsByPath(incidents []*Incident) map[string]*Incident {
+	open := map[string]*Incident{}
+	for _, incident := range incidents {
+		if incident.Open() {
+			open[incident.Path] = incident
+		}
+	}
+	return open
+}
+
+// sortIncidents orders open incidents before closed ones, each oldest first.
+func sortIncidents(incidents []*Incident) {
+	sort.SliceStable(incidents, func(i, j int) bool {
+		if incidents[i].Open() != incidents[j].Open() {
+			return incidents[i].Open()
+		}
+		if !incidents[i].OpenedAt.Equal(incidents[j].OpenedAt) {
+			return incidents[i].OpenedAt.Before(incidents[j].OpenedAt)
+		}
+		return incidents[i].Path < incidents[j].Path
+	})
+}
+
+func (i *Incident) String() string {
+	flagged := fmt.Sprintf("flagged %d times", i.TimesFlagged)
+	if i.Open() {
+		return fmt.Sprintf("OPEN    %s: %s since %s, last %s", i.Path, flagged,
+			formatIncidentTime(i.OpenedAt), formatIncidentTime(i.LastFlaggedAt))
+	}
+	return fmt.Sprintf("CLOSED  %s: %s from %s, %s %s", i.Path, flagged,
+		formatIncidentTime(i.OpenedAt), i.Resolution, formatIncidentTime(*i.ClosedAt))
+}
+
+func formatIncidentTime(t time.Time) string {
+	return t.Local().Format(time.RFC1123)
+}
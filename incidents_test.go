@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateIncidents(t *testing.T) {
+	modTime := time.Now().UTC()
+	oldManifest := &Manifest{Entries: map[string]ChecksumRecord{
+		"corrupted": {Checksum: "good", ModTime: modTime},
+		"repaired":  {Checksum: "good", ModTime: modTime},
+		"edited":    {Checksum: "good", ModTime: modTime},
+		"unchecked": {Checksum: "good", ModTime: modTime},
+	}}
+	newManifest := &Manifest{Entries: map[string]ChecksumRecord{
+		"corrupted": {Checksum: "bad", ModTime: modTime},
+		"repaired":  {Checksum: "bad", ModTime: modTime},
+		"edited":    {Checksum: "bad", ModTime: modTime},
+		"unchecked": {Checksum: "bad", ModTime: modTime},
+	}}
+	first := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	incidents := updateIncidents(nil, CompareManifests(oldManifest, newManifest), first)
+	assert.Len(t, incidents, 4)
+	for _, incident := range incidents {
+		assert.True(t, incident.Open())
+		assert.Equal(t, 1, incident.TimesFlagged)
+		assert.Equal(t, "good", incident.ExpectedChecksum)
+		assert.Equal(t, "bad", incident.ActualChecksum)
+		assert.Equal(t, first, incident.OpenedAt)
+	}
+
+	// Second run: one still corrupted, one repaired, one edited, one not
+	// checked
+	newManifest.Entries["repaired"] = oldManifest.Entries["repaired"]
+	newManifest.Entries["edited"] = ChecksumRecord{Checksum: "new", ModTime: modTime.Add(time.Minute)}
+	delete(oldManifest.Entries, "unchecked")
+	delete(newManifest.Entries, "unchecked")
+	second := first.Add(24 * time.Hour)
+	incidents = updateIncidents(incidents, CompareManifests(oldManifest, newManifest), second)
+	assert.Len(t, incidents, 4)
+
+	sortIncidents(incidents)
+	byPath := map[string]*Incident{}
+	for _, incident := range incidents {
+		byPath[incident.Path] = incident
+	}
+	corrupted := byPath["corrupted"]
+	assert.True(t, corrupted.Open())
+	assert.Equal(t, 2, corrupted.TimesFlagged)
+	assert.Equal(t, first, corrupted.OpenedAt)
+	assert.Equal(t, second, corrupted.LastFlaggedAt)
+	assert.True(t, byPath["unchecked"].Open())
+	assert.Equal(t, 1, byPath["unchecked"].TimesFlagged)
+	assert.False(t, byPath["repaired"].Open())
+	assert.Equal(t, resolutionRepaired, byPath["repaired"].Resolution)
+	assert.Equal(t, second, *byPath["repaired"].ClosedAt)
+	assert.Equal(t, resolutionModified, byPath["edited"].Resolution)
+
+	// Open incidents are listed first
+	assert.True(t, incidents[0].Open())
+	assert.True(t, incidents[1].Open())
+	assert.False(t, incidents[2].Open())
+
+	// A file flagged again after its incident was closed gets a new incident
+	newManifest.Entries["repaired"] = ChecksumRecord{Checksum: "bad", ModTime: modTime}
+	incidents = updateIncidents(incidents, CompareManifests(oldManifest, newManifest), second.Add(time.Hour))
+	assert.Len(t, incidents, 5)
+}
+
+func TestCloseIncidents(t *testing.T) {
+	incidents := []*Incident{{Path: "foo"}, {Path: "bar"}}
+	at := time.Now()
+	closeIncidents(incidents, []string{"foo", "baz"}, at, resolutionAccepted)
+	assert.False(t, incidents[0].Open())
+	assert.Equal(t, resolutionAccepted, incidents[0].Resolution)
+	assert.True(t, incidents[1].Open())
+}
@@ -3,53 +3,303 @@ package main
 import (
 	"crypto/sha1"
 	"encoding/hex"
-	"golang.org/x/text/unicode/norm"
+	"fmt"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
 )
 
 // ChecksumRecord stores checksum and metadata for a file.
 type ChecksumRecord struct {
-	Checksum string    `json:"checksum"`
-	ModTime  time.Time `json:"mod_time"`
+	Checksum string      `json:"checksum"`
+	ModTime  time.Time   `json:"mod_time"`
+	Size     int64       `json:"size"`
+	Inode    uint64      `json:"inode,omitempty"`
+	CTime    time.Time   `json:"ctime"`
+	Mode     os.FileMode `json:"mode"`
+	Uid      uint32      `json:"uid"`
+	Gid      uint32      `json:"gid"`
+	Nlink    uint64      `json:"nlink,omitempty"`
+	// When the file's content was last read and checksummed
+	LastVerified time.Time `json:"last_verified"`
+	// Set when the checksum was copied from a previous manifest because the
+	// file appeared unchanged, rather than re-hashed.
+	CarriedForward bool `json:"carried_forward,omitempty"`
+	// When the file was first flagged for possible corruption. Flagged files
+	// keep their last known-good checksums until the flag is resolved.
+	FlaggedSince *time.Time `json:"flagged_since,omitempty"`
+	// Hashes of each chunk of the file, if the manifest has a chunk size
+	Chunks []string `json:"chunks,omitempty"`
+	// Checksums from additional algorithms, recorded while migrating a path
+	// from one hash algorithm to another.
+	AltChecksums map[string]string `json:"alt_checksums,omitempty"`
+	// Why the file couldn't be read when this record was made. Its checksums
+	// are then those from the last time it was read, if ever.
+	Unreadable string `json:"unreadable,omitempty"`
+	// Set when the file kept changing while it was hashed. Its checksums are
+	// then those from the last time it was hashed, if ever.
+	Unstable bool `json:"unstable,omitempty"`
+	// Target of the symlink at this path, if it is one. Its other fields are
+	// the link's own, unless symlinks were followed to hash what they point to.
+	LinkTarget string `json:"link_target,omitempty"`
 }
 
 // Manifest of all files under a path.
 type Manifest struct {
-	Path      string                    `json:"path"`
-	CreatedAt time.Time                 `json:"created_at"`
-	Entries   map[string]ChecksumRecord `json:"entries"`
+	Path          string    `json:"path"`
+	CreatedAt     time.Time `json:"created_at"`
+	Algorithm     string    `json:"algorithm"`
+	AltAlgorithms []string  `json:"alt_algorithms,omitempty"`
+	// Size of the chunks hashed for each entry, or zero if chunks weren't
+	// hashed
+	ChunkSize int64 `json:"chunk_size,omitempty"`
+	// File/directory names excluded when generating; nil for manifests from
+	// before exclusions were recorded.
+	Excludes []string                  `json:"excludes"`
+	Entries  map[string]ChecksumRecord `json:"entries"`
 }
 
 // NewManifest generates a Manifest from a directory path.
 func NewManifest(path string, config *Config) (*Manifest, error) {
-	entries, err := directoryChecksums(path, config)
+	algorithm := config.hashAlgorithm()
+	altAlgorithms := config.altHashAlgorithms()
+	pipeline := newHashPipeline(path, config, append([]string{algorithm}, altAlgorithms...))
+	var err error
+	if config.Quick {
+		pipeline.known, err = config.ManifestStorage().LatestManifestForPath(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.Resume {
+		pipeline.resumed, err = config.ManifestStorage().CheckpointForPath(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.Checkpoint {
+		pipeline.checkpoint = func(entries map[string]ChecksumRecord) error {
+			return config.ManifestStorage().SaveCheckpoint(&Manifest{
+				Path:          path,
+				CreatedAt:     time.Now().UTC(),
+				Algorithm:     algorithm,
+				AltAlgorithms: altAlgorithms,
+				ChunkSize:     int64(config.ChunkSize),
+				Entries:       entries,
+			})
+		}
+	}
+	entries, err := pipeline.run()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Manifest{
-		Path:      path,
-		CreatedAt: time.Now().UTC(),
-		Entries:   entries,
-	}, nil
+	createdAt := time.Now().UTC()
+	unverified := len(pipeline.unreadableDirs) > 0
+	for relPath, entry := range entries {
+		if entry.unverified() {
+			unverified = true
+		} else if !entry.CarriedForward {
+			entry.LastVerified = createdAt
+			entries[relPath] = entry
+		}
+	}
+
+	manifest := &Manifest{
+		Path:          path,
+		CreatedAt:     createdAt,
+		Algorithm:     algorithm,
+		AltAlgorithms: altAlgorithms,
+		ChunkSize:     int64(config.ChunkSize),
+		Excludes:      append([]string{}, config.ExcludedFiles...),
+		Entries:       entries,
+	}
+	if unverified {
+		baseline := pipeline.known
+		if baseline == nil {
+			baseline, err = config.ManifestStorage().LatestManifestForPath(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		manifest.keepUnverified(baseline, pipeline.unreadableDirs)
+	}
+	return manifest, nil
+}
+
+// HashAlgorithm returns the algorithm used for the manifest's checksums.
+// Manifests written before the algorithm was recorded always used SHA-1.
+func (m *Manifest) HashAlgorithm() string {
+	if m.Algorithm == "" {
+		return defaultHashAlgorithm
+	}
+	return m.Algorithm
+}
+
+// hasChecksumsFor reports whether the manifest's entries carry checksums
+// computed with the given algorithm.
+func (m *Manifest) hasChecksumsFor(algorithm string) bool {
+	if m.HashAlgorithm() == algorithm {
+		return true
+	}
+	for _, alt := range m.AltAlgorithms {
+		if alt == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// CarriedForwardCount returns the number of entries whose checksums were
+// copied from a previous manifest rather than re-hashed.
+func (m *Manifest) CarriedForwardCount() int {
+	count := 0
+	for _, entry := range m.Entries {
+		if entry.CarriedForward {
+			count++
+		}
+	}
+	return count
+}
+
+// flaggedOnlyAlgorithms lists the alternate algorithms of flagged entries that
+// have no checksum in the primary algorithm, having been flagged while
+// migrating from one algorithm to another.
+func (m *Manifest) flaggedOnlyAlgorithms() []string {
+	algorithms := []string{}
+	for _, entry := range m.Entries {
+		if entry.FlaggedSince == nil || entry.Checksum != "" {
+			continue
+		}
+		for _, algorithm := range m.AltAlgorithms {
+			if _, ok := entry.AltChecksums[algorithm]; ok && !containsString(algorithms, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms
+}
+
+// checksumFor returns the record's checksum computed with the given algorithm,
+// if there is one. manifestAlgorithm is the primary algorithm of the manifest
+// the record belongs to.
+func (r *ChecksumRecord) checksumFor(manifestAlgorithm, algorithm string) (string, bool) {
+	if manifestAlgorithm == algorithm {
+		return r.Checksum, true
+	}
+	sum, ok := r.AltChecksums[algorithm]
+	return sum, ok
+}
+
+// KeepKnownGood replaces flagged entries with their records from the baseline
+// manifest, marked with when they were first flagged, so that the corruption
+// is reported again by later comparisons instead of becoming the new baseline.
+func (m *Manifest) KeepKnownGood(baseline *Manifest, flaggedPaths []string) {
+	for _, path := range flaggedPaths {
+		m.Entries[path] = knownGoodRecord(baseline, m, path)
+	}
+}
+
+// Returns the baseline record for a path, marked as flagged.
+func knownGoodRecord(baseline, manifest *Manifest, path string) ChecksumRecord {
+	record := baselineRecord(baseline, manifest, path)
+	record.CarriedForward = false
+	record.Unreadable = ""
+	record.Unstable = false
+	if record.FlaggedSince == nil {
+		flaggedSince := manifest.CreatedAt
+		record.FlaggedSince = &flaggedSince
+	}
+	return record
+}
+
+// Returns the baseline record for a path, with checksums in the manifest's
+// algorithms. If the baseline doesn't have the primary algorithm (during a
+// migration) the primary checksum is left empty, which never matches.
+func baselineRecord(baseline, manifest *Manifest, path string) ChecksumRecord {
+	old := baseline.Entries[path]
+	record := old
+	record.Checksum, _ = old.checksumFor(baseline.HashAlgorithm(), manifest.HashAlgorithm())
+	record.AltChecksums = nil
+	for _, algorithm := range append([]string{baseline.HashAlgorithm()}, manifest.AltAlgorithms...) {
+		if algorithm == manifest.HashAlgorithm() || !manifest.hasChecksumsFor(algorithm) {
+			continue
+		}
+		if sum, ok := old.checksumFor(baseline.HashAlgorithm(), algorithm); ok {
+			if record.AltChecksums == nil {
+				record.AltChecksums = map[string]string{}
+			}
+			record.AltChecksums[algorithm] = sum
+		}
+	}
+	return record
+}
+
+// metadataChanges describes differences in permissions and ownership from
+// another record, or returns an empty string if there are none. Records from
+// before this metadata was stored don't count as changed.
+func (r *ChecksumRecord) metadataChanges(old *ChecksumRecord) string {
+	if old.Mode == 0 || r.Mode == 0 {
+		return ""
+	}
+	changes := []string{}
+	if r.Mode != old.Mode {
+		changes = append(changes, fmt.Sprintf("mode %s -> %s", old.Mode, r.Mode))
+	}
+	if r.Uid != old.Uid {
+		changes = append(changes, fmt.Sprintf("uid %d -> %d", old.Uid, r.Uid))
+	}
+	if r.Gid != old.Gid {
+		changes = append(changes, fmt.Sprintf("gid %d -> %d", old.Gid, r.Gid))
+	}
+	return strings.Join(changes, ", ")
 }
 
 // Private functions
 
-func generateChecksum(file string) (string, error) {
-	// TODO: experiment with varying buffer to determine optimal size
-	bufferSize := 10 * 1024 * 1024 // 10MiB buffer
-	reader, err := newSha1Reader(file, bufferSize)
+func generateChecksums(file string, algorithms []string) ([]string, error) {
+	checksums, _, err := hashFile(file, algorithms, hashOptions{})
+	return checksums, err
+}
+
+// hashOptions controls how a file is read for hashing.
+type hashOptions struct {
+	// Also hash chunks of this size within the file, if nonzero
+	chunkSize int64
+	// Read around the page cache in this cache mode, if set
+	cacheMode string
+	// Limits on reading shared with other files, if set
+	throttle *Throttle
+	// Told of the read's progress, if set
+	watch *stallWatch
+}
+
+// hashFile returns a file's checksums in each algorithm, and its chunk hashes
+// if options ask for them.
+func hashFile(file string, algorithms []string, options hashOptions) ([]string, []string, error) {
+	options.watch.pause()
+	options.throttle.waitForFile()
+	options.watch.resume()
+	reader, err := newChecksumReader(file, checksumBufferSize, algorithms...)
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+	options.watch.progress()
+	if options.chunkSize > 0 {
+		reader.hashChunks(options.chunkSize)
 	}
-	sum, err := reader.SHA1Sum()
+	reader.bypassCache(options.cacheMode)
+	reader.throttle = options.throttle
+	reader.watch = options.watch
+	sums, err := reader.Sums()
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	return hex.EncodeToString(sum), nil
+	checksums := []string{}
+	for _, sum := range sums {
+		checksums = append(checksums, hex.EncodeToString(sum))
+	}
+	return checksums, reader.Chunks(), nil
 }
 
 func checksumHexString(data *[]byte) string {
@@ -57,43 +307,63 @@ func checksumHexString(data *[]byte) string {
 	return hex.EncodeToString(sum[:])
 }
 
-func directoryChecksums(path string, config *Config) (map[string]ChecksumRecord, error) {
-	records := map[string]ChecksumRecord{}
-	err := filepath.Walk(path, func(entryPath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// Builds a record from checksums ordered as in algorithms, the first of which
+// is the primary algorithm.
+func newChecksumRecord(info os.FileInfo, algorithms []string, checksums []string) ChecksumRecord {
+	stat := statFromInfo(info)
+	record := ChecksumRecord{
+		Checksum: checksums[0],
+		ModTime:  info.ModTime().UTC(),
+		Size:     info.Size(),
+		Inode:    stat.Inode,
+		CTime:    stat.CTime,
+		Mode:     info.Mode(),
+		Uid:      stat.Uid,
+		Gid:      stat.Gid,
+		Nlink:    stat.Nlink,
+	}
+	if len(algorithms) > 1 {
+		record.AltChecksums = map[string]string{}
+		for i, algorithm := range algorithms[1:] {
+			record.AltChecksums[algorithm] = checksums[i+1]
 		}
+	}
+	return record
+}
 
-		if config.isIgnoredPath(entryPath) {
-			if info.IsDir() {
-				// Skip walking this directory
-				return filepath.SkipDir
-			}
-			return nil
-		}
+// carryForward returns a copy of the known record for a file whose size,
+// modification and change times, and inode are all unchanged, provided it has
+// checksums for all the algorithms and chunk hashes of chunkSize, if nonzero.
+func carryForward(known *Manifest, relPath string, info os.FileInfo, algorithms []string, chunkSize int64) (ChecksumRecord, bool) {
+	if known == nil || (chunkSize != 0 && known.ChunkSize != chunkSize) {
+		return ChecksumRecord{}, false
+	}
+	old, ok := known.Entries[relPath]
+	// Flagged and previously unreadable or unstable files are always re-hashed
+	if !ok || old.FlaggedSince != nil || old.unverified() {
+		return ChecksumRecord{}, false
+	}
+	stat := statFromInfo(info)
+	if old.Size != info.Size() ||
+		!old.ModTime.Equal(info.ModTime()) ||
+		!old.CTime.Equal(stat.CTime) ||
+		old.Inode != stat.Inode {
+		return ChecksumRecord{}, false
+	}
 
-		if info.Mode().IsRegular() {
-			var relPath string
-			relPath, err = filepath.Rel(path, entryPath)
-			if err != nil {
-				return err
-			}
-			// Normalize Unicode combining characters
-			relPath = norm.NFC.String(relPath)
-			checksum, err := generateChecksum(entryPath)
-			if err != nil {
-				return err
-			}
-			records[relPath] = ChecksumRecord{
-				Checksum: checksum,
-				ModTime:  info.ModTime().UTC(),
-			}
+	checksums := []string{}
+	for _, algorithm := range algorithms {
+		sum, ok := old.checksumFor(known.HashAlgorithm(), algorithm)
+		if !ok {
+			return ChecksumRecord{}, false
 		}
-
-		return nil
-	})
-	if err != nil {
-		return nil, err
+		checksums = append(checksums, sum)
+	}
+	record := newChecksumRecord(info, algorithms, checksums)
+	if chunkSize != 0 {
+		record.Chunks = old.Chunks
 	}
-	return records, nil
+	record.LastVerified = old.LastVerified
+	record.CarriedForward = true
+	return record, true
 }
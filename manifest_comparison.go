@@ -1,18 +1,30 @@
 package main
 
+import "time"
+
 // ManifestComparison of two Manifests, showing paths that have been deleted,
-// added, renamed, modified, or flagged for suspicious checksum changes
-// (indicating possible corruption).
+// added, renamed, modified, had only their metadata changed, flagged for
+// suspicious checksum changes (indicating possible corruption), couldn't be
+// read for the new manifest, or kept changing while they were hashed, and
+// symlinks that have been added, deleted, or retargeted.
 type ManifestComparison struct {
-	UnchangedPaths []string
-	DeletedPaths   []string
-	AddedPaths     []string
-	RenamedPaths   []RenamedPath
-	ModifiedPaths  []string
-	FlaggedPaths   []string
-	oldManifest    *Manifest
-	newManifest    *Manifest
-	complete       bool
+	UnchangedPaths       []string
+	DeletedPaths         []string
+	AddedPaths           []string
+	RenamedPaths         []RenamedPath
+	ModifiedPaths        []string
+	MetadataChangedPaths []string
+	FlaggedPaths         []string
+	UnreadablePaths      []string
+	UnstablePaths        []string
+	AddedLinks           []string
+	DeletedLinks         []string
+	RetargetedLinks      []string
+	oldManifest          *Manifest
+	newManifest          *Manifest
+	complete             bool
+	// Results of re-reading flagged paths, if they were confirmed
+	rereads map[string]*Rereads
 }
 
 // RenamedPath tracks a path that has been moved/renamed but has the same
@@ -30,7 +42,7 @@ func CompareManifests(oldManifest, newManifest *Manifest) *ManifestComparison {
 }
 
 func (comp *ManifestComparison) Success() bool {
-	return len(comp.FlaggedPaths) == 0
+	return len(comp.FlaggedPaths) == 0 && len(comp.UnreadablePaths) == 0
 }
 
 func (comp *ManifestComparison) TotalChecked() int {
@@ -39,7 +51,123 @@ func (comp *ManifestComparison) TotalChecked() int {
 		len(comp.AddedPaths) +
 		len(comp.RenamedPaths) +
 		len(comp.ModifiedPaths) +
-		len(comp.FlaggedPaths)
+		len(comp.MetadataChangedPaths) +
+		len(comp.FlaggedPaths) +
+		len(comp.UnreadablePaths) +
+		len(comp.UnstablePaths) +
+		len(comp.AddedLinks) +
+		len(comp.DeletedLinks) +
+		len(comp.RetargetedLinks)
+}
+
+// OldBytes totals the sizes of paths in the old manifest.
+func (comp *ManifestComparison) OldBytes(paths []string) int64 {
+	return totalSize(comp.oldManifest, paths)
+}
+
+// NewBytes totals the sizes of paths in the new manifest.
+func (comp *ManifestComparison) NewBytes(paths []string) int64 {
+	return totalSize(comp.newManifest, paths)
+}
+
+// RenamedBytes totals the sizes of renamed paths.
+func (comp *ManifestComparison) RenamedBytes() int64 {
+	paths := []string{}
+	for _, renamed := range comp.RenamedPaths {
+		paths = append(paths, renamed.NewPath)
+	}
+	return comp.NewBytes(paths)
+}
+
+// MetadataChanges describes how a path's metadata changed.
+func (comp *ManifestComparison) MetadataChanges(path string) string {
+	oldEntry := comp.oldManifest.Entries[path]
+	newEntry := comp.newManifest.Entries[path]
+	return newEntry.metadataChanges(&oldEntry)
+}
+
+// UnreadableReason returns why a path couldn't be read for the new manifest.
+func (comp *ManifestComparison) UnreadableReason(path string) string {
+	return comp.newManifest.Entries[path].Unreadable
+}
+
+// LinkTargets returns the targets of a symlink in the old and new manifests,
+// empty where it isn't a symlink.
+func (comp *ManifestComparison) LinkTargets(path string) (oldTarget, newTarget string) {
+	return comp.oldManifest.Entries[path].LinkTarget, comp.newManifest.Entries[path].LinkTarget
+}
+
+// FlaggedSince returns when a flagged path was first flagged by an earlier
+// comparison, or nil if this is the first time.
+func (comp *ManifestComparison) FlaggedSince(path string) *time.Time {
+	return comp.oldManifest.Entries[path].FlaggedSince
+}
+
+// ChangedRanges returns the byte ranges that differ between the old and new
+// versions of a path, or nil if the manifests don't both have chunk hashes of
+// the same size for it.
+func (comp *ManifestComparison) ChangedRanges(path string) []ByteRange {
+	chunkSize := comp.oldManifest.ChunkSize
+	if chunkSize == 0 || comp.newManifest.ChunkSize != chunkSize {
+		return nil
+	}
+	oldEntry, ok := comp.oldManifest.Entries[path]
+	if !ok {
+		return nil
+	}
+	newEntry, ok := comp.newManifest.Entries[path]
+	if !ok {
+		return nil
+	}
+	// Chunks are only missing if the file is empty
+	if (oldEntry.Chunks == nil && oldEntry.Size > 0) || (newEntry.Chunks == nil && newEntry.Size > 0) {
+		return nil
+	}
+	return changedRanges(oldEntry.Chunks, newEntry.Chunks, chunkSize, oldEntry.Size, newEntry.Size)
+}
+
+// ExclusionChanges lists names excluded only when generating the new manifest
+// (added) or only the old one (removed). Differences mean some added or deleted
+// paths may just be newly excluded or included. Manifests that don't record
+// their exclusions can't be checked.
+func (comp *ManifestComparison) ExclusionChanges() (added, removed []string) {
+	if comp.oldManifest.Excludes == nil || comp.newManifest.Excludes == nil {
+		return nil, nil
+	}
+	return stringsMissingFrom(comp.newManifest.Excludes, comp.oldManifest.Excludes),
+		stringsMissingFrom(comp.oldManifest.Excludes, comp.newManifest.Excludes)
+}
+
+// Returns strings in a but not in b
+func stringsMissingFrom(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, s := range b {
+		inB[s] = true
+	}
+	missing := []string{}
+	for _, s := range a {
+		if !inB[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func totalSize(manifest *Manifest, paths []string) int64 {
+	var total int64
+	for _, path := range paths {
+		total += manifest.Entries[path].Size
+	}
+	return total
 }
 
 func (comp *ManifestComparison) compare() {
@@ -48,16 +176,38 @@ func (comp *ManifestComparison) compare() {
 		return
 	}
 
-	// First look for paths added in new
-	for path := range comp.newManifest.Entries {
-		_, oldEntryPresent := comp.oldManifest.Entries[path]
-		if !oldEntryPresent {
+	// First look for paths added in new, and those that couldn't be read or
+	// kept changing
+	for path, newEntry := range comp.newManifest.Entries {
+		if newEntry.Unreadable != "" {
+			comp.UnreadablePaths = append(comp.UnreadablePaths, path)
+			continue
+		}
+		if newEntry.Unstable {
+			comp.UnstablePaths = append(comp.UnstablePaths, path)
+			continue
+		}
+		oldEntry, oldEntryPresent := comp.oldManifest.Entries[path]
+		if newEntry.isLink() {
+			if !oldEntryPresent || !oldEntry.isLink() {
+				comp.AddedLinks = append(comp.AddedLinks, path)
+			}
+			continue
+		}
+		// A file never read before, or that was a symlink, has nothing to
+		// compare with
+		if !oldEntryPresent || oldEntry.neverRead() || oldEntry.isLink() {
 			comp.AddedPaths = append(comp.AddedPaths, path)
 		}
 	}
 
 	// Then look for modifications, deletions, renames, or corruptions of files from old to new
 	for path, oldEntry := range comp.oldManifest.Entries {
+		if oldEntry.isLink() {
+			comp.handleLink(path, &oldEntry)
+			continue
+		}
+
 		// Handle a matching path entry in new manifest
 		if comp.handleEntry(path, &oldEntry) {
 			continue
@@ -77,12 +227,46 @@ func (comp *ManifestComparison) compare() {
 
 func (comp *ManifestComparison) handleEntry(path string, oldEntry *ChecksumRecord) bool {
 	newEntry, newEntryPresent := comp.newManifest.Entries[path]
-	if !newEntryPresent {
+	if !newEntryPresent || newEntry.isLink() {
 		return false
 	}
+	if newEntry.unverified() || oldEntry.neverRead() {
+		// Already counted with the new manifest's entries
+		return true
+	}
+	comp.compareContent(path, oldEntry, &newEntry)
+	return true
+}
 
-	if newEntry.Checksum == oldEntry.Checksum {
+// handleLink compares a symlink from the old manifest with the new one's entry
+// for its path. When both followed the link to hash what it points to, the
+// content is compared as for any file.
+func (comp *ManifestComparison) handleLink(path string, oldEntry *ChecksumRecord) {
+	newEntry, newEntryPresent := comp.newManifest.Entries[path]
+	if !newEntryPresent || !newEntry.isLink() {
+		comp.DeletedLinks = append(comp.DeletedLinks, path)
+		return
+	}
+	if newEntry.unverified() || oldEntry.neverRead() {
+		// Already counted with the new manifest's entries
+		return
+	}
+	if newEntry.LinkTarget != oldEntry.LinkTarget {
+		comp.RetargetedLinks = append(comp.RetargetedLinks, path)
+	} else if oldEntry.linkOnly() || newEntry.linkOnly() {
 		comp.UnchangedPaths = append(comp.UnchangedPaths, path)
+	} else {
+		comp.compareContent(path, oldEntry, &newEntry)
+	}
+}
+
+func (comp *ManifestComparison) compareContent(path string, oldEntry, newEntry *ChecksumRecord) {
+	if comp.sameContent(oldEntry, newEntry) {
+		if newEntry.metadataChanges(oldEntry) != "" {
+			comp.MetadataChangedPaths = append(comp.MetadataChangedPaths, path)
+		} else {
+			comp.UnchangedPaths = append(comp.UnchangedPaths, path)
+		}
 	} else {
 		if newEntry.ModTime != oldEntry.ModTime {
 			// Content change plus mod time change = intended modification
@@ -92,12 +276,13 @@ func (comp *ManifestComparison) handleEntry(path string, oldEntry *ChecksumRecor
 			comp.FlaggedPaths = append(comp.FlaggedPaths, path)
 		}
 	}
-
-	return true
 }
 
 func (comp *ManifestComparison) handleRenamedEntry(path string, oldEntry *ChecksumRecord) bool {
-	newPath := comp.findRenamedPathByChecksum(oldEntry.Checksum)
+	if oldEntry.neverRead() {
+		return false
+	}
+	newPath := comp.findRenamedPath(oldEntry)
 	if newPath == "" {
 		return false
 	}
@@ -115,11 +300,36 @@ func (comp *ManifestComparison) handleRenamedEntry(path string, oldEntry *Checks
 	return true
 }
 
-func (comp *ManifestComparison) findRenamedPathByChecksum(checksum string) string {
+func (comp *ManifestComparison) findRenamedPath(oldEntry *ChecksumRecord) string {
 	for _, newPath := range comp.AddedPaths {
-		if comp.newManifest.Entries[newPath].Checksum == checksum {
+		newEntry := comp.newManifest.Entries[newPath]
+		if comp.sameContent(oldEntry, &newEntry) {
 			return newPath
 		}
 	}
 	return ""
 }
+
+// sameContent compares checksums from an algorithm both entries have, which
+// may be an alternate algorithm if the manifests were generated with different
+// ones, or if the old entry was flagged while migrating algorithms and so has
+// no primary checksum.
+func (comp *ManifestComparison) sameContent(oldEntry, newEntry *ChecksumRecord) bool {
+	oldAlgorithm := comp.oldManifest.HashAlgorithm()
+	newAlgorithm := comp.newManifest.HashAlgorithm()
+	for _, algorithm := range append([]string{oldAlgorithm, newAlgorithm}, comp.oldManifest.AltAlgorithms...) {
+		oldSum, oldOK := oldEntry.checksumFor(oldAlgorithm, algorithm)
+		newSum, newOK := newEntry.checksumFor(newAlgorithm, algorithm)
+		if oldOK && newOK && oldSum != "" && newSum != "" {
+			return oldSum == newSum
+		}
+	}
+	return false
+}
+
+// ComparableManifests reports whether two manifests share a hash algorithm,
+// without which their content can't be compared.
+func ComparableManifests(oldManifest, newManifest *Manifest) bool {
+	return newManifest.hasChecksumsFor(oldManifest.HashAlgorithm()) ||
+		oldManifest.hasChecksumsFor(newManifest.HashAlgorithm())
+}
@@ -78,3 +78,73 @@ func TestCaching(t *testing.T) {
 	assert.Empty(t, comparison.AddedPaths)
 	assert.Empty(t, comparison.RenamedPaths)
 }
+
+func TestManifestComparisonAcrossHashAlgorithms(t *testing.T) {
+	modTime := time.Now()
+	oldManifest := &Manifest{
+		Path: "/stuff",
+		Entries: map[string]ChecksumRecord{
+			"not_changed": {Checksum: "sha1-a", ModTime: modTime},
+			"corrupted":   {Checksum: "sha1-b", ModTime: modTime},
+			"renamedOld":  {Checksum: "sha1-c", ModTime: modTime},
+		},
+	}
+	newManifest := &Manifest{
+		Path:          "/stuff",
+		Algorithm:     "sha256",
+		AltAlgorithms: []string{"sha1"},
+		Entries: map[string]ChecksumRecord{
+			"not_changed": {Checksum: "sha256-a", ModTime: modTime, AltChecksums: map[string]string{"sha1": "sha1-a"}},
+			"corrupted":   {Checksum: "sha256-x", ModTime: modTime, AltChecksums: map[string]string{"sha1": "sha1-x"}},
+			"renamedNew":  {Checksum: "sha256-c", ModTime: modTime, AltChecksums: map[string]string{"sha1": "sha1-c"}},
+		},
+	}
+	assert.True(t, ComparableManifests(oldManifest, newManifest))
+
+	comparison := CompareManifests(oldManifest, newManifest)
+	assert.ElementsMatch(t, comparison.UnchangedPaths, []string{"not_changed"})
+	assert.ElementsMatch(t, comparison.FlaggedPaths, []string{"corrupted"})
+	assert.ElementsMatch(t, comparison.RenamedPaths, []RenamedPath{{OldPath: "renamedOld", NewPath: "renamedNew"}})
+
+	// Once migrated, later manifests only need the new algorithm
+	laterManifest := &Manifest{
+		Path:      "/stuff",
+		Algorithm: "sha256",
+		Entries: map[string]ChecksumRecord{
+			"not_changed": {Checksum: "sha256-a", ModTime: modTime},
+		},
+	}
+	assert.True(t, ComparableManifests(newManifest, laterManifest))
+	assert.False(t, ComparableManifests(oldManifest, laterManifest))
+}
+
+func TestManifestComparisonMetadataChanges(t *testing.T) {
+	modTime := time.Now()
+	oldManifest := &Manifest{
+		Path: "/stuff",
+		Entries: map[string]ChecksumRecord{
+			"not_changed": {Checksum: "a", ModTime: modTime, Size: 10, Mode: 0644, Uid: 501, Gid: 20},
+			"chmodded":    {Checksum: "b", ModTime: modTime, Size: 20, Mode: 0644, Uid: 501, Gid: 20},
+			"chowned":     {Checksum: "c", ModTime: modTime, Size: 30, Mode: 0644, Uid: 501, Gid: 20},
+			"legacy":      {Checksum: "d", ModTime: modTime},
+		},
+	}
+	newManifest := &Manifest{
+		Path: "/stuff",
+		Entries: map[string]ChecksumRecord{
+			"not_changed": {Checksum: "a", ModTime: modTime, Size: 10, Mode: 0644, Uid: 501, Gid: 20, Inode: 2},
+			"chmodded":    {Checksum: "b", ModTime: modTime, Size: 20, Mode: 0600, Uid: 501, Gid: 20},
+			"chowned":     {Checksum: "c", ModTime: modTime, Size: 30, Mode: 0644, Uid: 0, Gid: 0},
+			"legacy":      {Checksum: "d", ModTime: modTime, Size: 40, Mode: 0644, Uid: 501, Gid: 20},
+		},
+	}
+
+	comparison := CompareManifests(oldManifest, newManifest)
+	assert.ElementsMatch(t, comparison.UnchangedPaths, []string{"not_changed", "legacy"})
+	assert.ElementsMatch(t, comparison.MetadataChangedPaths, []string{"chmodded", "chowned"})
+	assert.Equal(t, 4, comparison.TotalChecked())
+	assert.Equal(t, "mode -rw-r--r-- -> -rw-------", comparison.MetadataChanges("chmodded"))
+	assert.Equal(t, "uid 501 -> 0, gid 20 -> 0", comparison.MetadataChanges("chowned"))
+	assert.Equal(t, int64(50), comparison.NewBytes(comparison.MetadataChangedPaths))
+	assert.Equal(t, int64(10), comparison.OldBytes([]string{"not_changed", "legacy"}))
+}
@@ -11,14 +11,21 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"time"
 )
 
 const (
 	manifestGlob         = "manifest-*.json"
 	manifestNameTemplate = "manifest-%s-%s.json"
-	// RFC3339 minus punctuation characters, better for filenames
-	manifestNameTimeFormat      = "20060102T150405Z07:00"
+	// RFC3339 minus punctuation characters, better for filenames. Fractional
+	// seconds are fixed width so that names sort chronologically.
+	manifestNameTimeFormat      = "20060102T150405.000000000Z07:00"
 	manifestStorageMetadataName = "bitrot_meta.json"
+	checkpointName              = "checkpoint.json"
+	// Acceptances, one JSON object per line
+	acceptanceLogName = "accepted.jsonl"
+	incidentsName     = "incidents.json"
+	parityDirName     = "parity"
 )
 
 type ManifestStorage struct {
@@ -104,6 +111,190 @@ func (m *ManifestStorage) LatestManifestForPath(path string) (*Manifest, error)
 	return m.readManifestFile(manifestPaths[0])
 }
 
+// SaveCheckpoint replaces the checkpoint for the manifest's path with a
+// partial manifest.
+func (m *ManifestStorage) SaveCheckpoint(manifest *Manifest) error {
+	jsonBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	manifestDir, err := m.addPath(manifest.Path)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interruption can't leave a
+	// truncated checkpoint
+	checkpointPath := filepath.Join(manifestDir, checkpointName)
+	tempPath := checkpointPath + ".tmp"
+	err = ioutil.WriteFile(tempPath, jsonBytes, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, checkpointPath)
+}
+
+// CheckpointForPath returns the checkpoint of an interrupted run for the path,
+// or nil if there is none.
+func (m *ManifestStorage) CheckpointForPath(path string) (*Manifest, error) {
+	manifestDir, err := m.addPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpointPath := filepath.Join(manifestDir, checkpointName)
+	if _, err := os.Stat(checkpointPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return m.readManifestFile(checkpointPath)
+}
+
+func (m *ManifestStorage) HasCheckpoint(path string) bool {
+	_, err := os.Stat(filepath.Join(m.storageForPath(path), checkpointName))
+	return err == nil
+}
+
+// RemoveCheckpoint removes the checkpoint for the path, if there is one.
+func (m *ManifestStorage) RemoveCheckpoint(path string) error {
+	manifestDir, err := m.addPath(path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(manifestDir, checkpointName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// AddAcceptance appends to the log of files accepted for a path.
+func (m *ManifestStorage) AddAcceptance(path string, acceptance *Acceptance) error {
+	jsonBytes, err := json.Marshal(acceptance)
+	if err != nil {
+		return err
+	}
+
+	manifestDir, err := m.addPath(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(manifestDir, acceptanceLogName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(jsonBytes, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// AcceptancesForPath returns the files accepted for a path, oldest first.
+func (m *ManifestStorage) AcceptancesForPath(path string) ([]*Acceptance, error) {
+	manifestDir, err := m.addPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(manifestDir, acceptanceLogName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	acceptances := []*Acceptance{}
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var acceptance Acceptance
+		if err = decoder.Decode(&acceptance); err != nil {
+			return nil, err
+		}
+		acceptances = append(acceptances, &acceptance)
+	}
+	return acceptances, nil
+}
+
+// IncidentsForPath returns the open and closed incidents for a path, or nil if
+// it has none. Looking doesn't add the path to storage.
+func (m *ManifestStorage) IncidentsForPath(path string) ([]*Incident, error) {
+	jsonBytes, err := ioutil.ReadFile(filepath.Join(m.storageForPath(path), incidentsName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var incidents []*Incident
+	err = json.Unmarshal(jsonBytes, &incidents)
+	if err != nil {
+		return nil, err
+	}
+	return incidents, nil
+}
+
+// SaveIncidents replaces the incidents for a path.
+func (m *ManifestStorage) SaveIncidents(path string, incidents []*Incident) error {
+	jsonBytes, err := json.Marshal(incidents)
+	if err != nil {
+		return err
+	}
+
+	manifestDir, err := m.addPath(path)
+	if err != nil {
+		return err
+	}
+
+	incidentsPath := filepath.Join(manifestDir, incidentsName)
+	tempPath := incidentsPath + ".tmp"
+	err = ioutil.WriteFile(tempPath, jsonBytes, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, incidentsPath)
+}
+
+// RecordIncidents updates the incidents for a path with the results of a
+// comparison.
+func (m *ManifestStorage) RecordIncidents(path string, comparison *ManifestComparison, at time.Time) error {
+	incidents, err := m.IncidentsForPath(path)
+	if err != nil {
+		return err
+	}
+	return m.SaveIncidents(path, updateIncidents(incidents, comparison, at))
+}
+
+// ResolveIncidents closes any open incidents for files under a path.
+func (m *ManifestStorage) ResolveIncidents(path string, relPaths []string, resolution string, at time.Time) error {
+	incidents, err := m.IncidentsForPath(path)
+	if err != nil {
+		return err
+	}
+	closeIncidents(incidents, relPaths, at, resolution)
+	return m.SaveIncidents(path, incidents)
+}
+
+// ParityForPath returns the store for parity of files under a path.
+func (m *ManifestStorage) ParityForPath(path string) (*parityStore, error) {
+	manifestDir, err := m.addPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parityDir := filepath.Join(manifestDir, parityDirName)
+	err = os.MkdirAll(parityDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &parityStore{dir: parityDir}, nil
+}
+
 func (m *ManifestStorage) readManifestFile(path string) (*Manifest, error) {
 	jsonBytes, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -41,3 +41,50 @@ func TestManifestStorage(t *testing.T) {
 	assert.Len(t, entries, 1)
 	assert.Equal(t, testPath, entries[0].Path)
 }
+
+func TestManifestStorageCheckpoint(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	s := NewManifestStorage(tempDir)
+	testPath := "/foo/bar/baz"
+	checkpoint, err := s.CheckpointForPath(testPath)
+	assert.Nil(t, err)
+	assert.Nil(t, checkpoint)
+	assert.False(t, s.HasCheckpoint(testPath))
+
+	manifest := &Manifest{
+		Path:    testPath,
+		Entries: map[string]ChecksumRecord{"foo": {Checksum: "asdf"}},
+	}
+	assert.Nil(t, s.SaveCheckpoint(manifest))
+	assert.True(t, s.HasCheckpoint(testPath))
+	checkpoint, err = s.CheckpointForPath(testPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "asdf", checkpoint.Entries["foo"].Checksum)
+
+	// Checkpoints aren't manifests
+	latest, err := s.LatestManifestForPath(testPath)
+	assert.Nil(t, err)
+	assert.Nil(t, latest)
+
+	assert.Nil(t, s.RemoveCheckpoint(testPath))
+	assert.False(t, s.HasCheckpoint(testPath))
+	assert.Nil(t, s.RemoveCheckpoint(testPath))
+}
+
+func TestManifestStorageIncidentsForUnknownPath(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	s := NewManifestStorage(tempDir)
+	incidents, err := s.IncidentsForPath("/foo/bar/baz")
+	assert.Nil(t, err)
+	assert.Nil(t, incidents)
+
+	entries, err := s.List()
+	assert.Nil(t, err)
+	assert.Len(t, entries, 0)
+}
@@ -165,3 +165,44 @@ func TestManifestJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestQuickManifest(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	configDir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(configDir)
+
+	populateTestDirectory(t, tempDir)
+	config := Config{Dir: configDir}
+	manifest, err := NewManifest(tempDir, &config)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, manifest.CarriedForwardCount())
+
+	// Doctor the stored checksum to show it gets reused
+	record := manifest.Entries["foo"]
+	record.Checksum = "stored"
+	manifest.Entries["foo"] = record
+	assert.Nil(t, config.ManifestStorage().AddManifest(manifest))
+
+	modTime := time.Now().Add(time.Minute)
+	subdirFile := filepath.Join(tempDir, "bar", "baz", "stuff", "foo")
+	assert.Nil(t, os.Chtimes(subdirFile, modTime, modTime))
+
+	config.Quick = true
+	quickManifest, err := NewManifest(tempDir, &config)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, quickManifest.CarriedForwardCount())
+	assert.Equal(t, "stored", quickManifest.Entries["foo"].Checksum)
+	assert.True(t, quickManifest.Entries["foo"].CarriedForward)
+	assert.Equal(t, helloWorldChecksum, quickManifest.Entries["bar/baz/stuff/foo"].Checksum)
+	assert.False(t, quickManifest.Entries["bar/baz/stuff/foo"].CarriedForward)
+	assert.Equal(t, int64(len(helloWorldString)), quickManifest.Entries["bar/baz/stuff/foo"].Size)
+
+	// A different algorithm can't be carried forward
+	config.HashAlgorithm = "sha256"
+	quickManifest, err = NewManifest(tempDir, &config)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, quickManifest.CarriedForwardCount())
+}
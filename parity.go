@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+const (
+	// Files are split into shards, and each stripe of up to this many data
+	// shards gets its own parity shards, so the redundancy percentage is also
+	// the number of parity shards per full stripe.
+	parityStripeShards = 100
+	minParityShardSize = 4 * 1024
+	maxParityShardSize = 256 * 1024
+	parityRecordSuffix = ".json"
+	parityDataSuffix   = ".par"
+)
+
+var errParityStale = errors.New("file changed since it was hashed")
+
+// ParityRecord describes the Reed-Solomon parity stored for a file. Each shard
+// has a CRC so that damaged shards can be located and reconstructed.
+type ParityRecord struct {
+	Path string `json:"path"`
+	// Checksum of the content the parity was computed from
+	Algorithm  string   `json:"algorithm"`
+	Checksum   string   `json:"checksum"`
+	Size       int64    `json:"size"`
+	ShardSize  int      `json:"shard_size"`
+	Redundancy int      `json:"redundancy"`
+	DataCRCs   []uint32 `json:"data_crcs"`
+	ParityCRCs []uint32 `json:"parity_crcs"`
+}
+
+type parityStripe struct {
+	dataShards   int
+	parityShards int
+}
+
+// stripes returns the layout of a file's shards.
+func (r *ParityRecord) stripes() []parityStripe {
+	shards := int((r.Size + int64(r.ShardSize) - 1) / int64(r.ShardSize))
+	stripes := []parityStripe{}
+	for shards > 0 {
+		data := shards
+		if data > parityStripeShards {
+			data = parityStripeShards
+		}
+		// At least one parity shard, however small the stripe
+		parity := (data*r.Redundancy + 99) / 100
+		stripes = append(stripes, parityStripe{dataShards: data, parityShards: parity})
+		shards -= data
+	}
+	return stripes
+}
+
+func parityShardSize(size int64) int {
+	shardSize := (size + parityStripeShards - 1) / parityStripeShards
+	if shardSize < minParityShardSize {
+		return minParityShardSize
+	}
+	if shardSize > maxParityShardSize {
+		return maxParityShardSize
+	}
+	return int(shardSize)
+}
+
+func shardCRC(shard []byte) uint32 {
+	return crc32.Checksum(shard, crc32cTable)
+}
+
+// Reads the next shard into buffer, zero-padding past the end of the file.
+func readShard(reader io.Reader, buffer []byte) ([]byte, error) {
+	n, err := io.ReadFull(reader, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	for i := n; i < len(buffer); i++ {
+		buffer[i] = 0
+	}
+	return buffer, err
+}
+
+// parityStore holds the parity for the files under one path, in a directory in
+// manifest storage.
+type parityStore struct {
+	dir string
+}
+
+// Parity files are named by a hash of the relative path.
+func (s *parityStore) filename(relPath, suffix string) string {
+	sum := sha256.Sum256([]byte(relPath))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+suffix)
+}
+
+// Load returns the parity record for a file, or nil if there is none.
+func (s *parityStore) Load(relPath string) (*ParityRecord, error) {
+	jsonBytes, err := ioutil.ReadFile(s.filename(relPath, parityRecordSuffix))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record ParityRecord
+	err = json.Unmarshal(jsonBytes, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Generate computes parity for a file under root, provided its content still
+// has the checksum in entry.
+func (s *parityStore) Generate(root, relPath string, entry ChecksumRecord, algorithm string, redundancy int) error {
+	record := &ParityRecord{
+		Path:       relPath,
+		Algorithm:  algorithm,
+		Checksum:   entry.Checksum,
+		Size:       entry.Size,
+		ShardSize:  parityShardSize(entry.Size),
+		Redundancy: redundancy,
+	}
+	file, err := os.Open(filepath.Join(root, relPath))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h, err := newHash(algorithm)
+	if err != nil {
+		return err
+	}
+	// Only the recorded size is hashed and covered; a file that has since
+	// grown is caught after
+	reader := io.TeeReader(io.LimitReader(file, entry.Size), h)
+
+	dataPath := s.filename(relPath, parityDataSuffix)
+	data, err := ioutil.TempFile(s.dir, filepath.Base(dataPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(data.Name())
+	defer data.Close()
+
+	for _, stripe := range record.stripes() {
+		shards := make([][]byte, stripe.dataShards+stripe.parityShards)
+		for i := range shards {
+			shards[i] = make([]byte, record.ShardSize)
+		}
+		for i := 0; i < stripe.dataShards; i++ {
+			_, err = readShard(reader, shards[i])
+			if err != nil {
+				return err
+			}
+			record.DataCRCs = append(record.DataCRCs, shardCRC(shards[i]))
+		}
+		encoder, err := reedsolomon.New(stripe.dataShards, stripe.parityShards)
+		if err != nil {
+			return err
+		}
+		err = encoder.Encode(shards)
+		if err != nil {
+			return err
+		}
+		for _, shard := range shards[stripe.dataShards:] {
+			record.ParityCRCs = append(record.ParityCRCs, shardCRC(shard))
+			_, err = data.Write(shard)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if hex.EncodeToString(h.Sum(nil)) != entry.Checksum {
+		return errParityStale
+	}
+	if n, _ := file.Read(make([]byte, 1)); n > 0 {
+		return errParityStale
+	}
+
+	err = data.Close()
+	if err != nil {
+		return err
+	}
+	// Without a record, parity data that was being replaced is never used
+	recordPath := s.filename(relPath, parityRecordSuffix)
+	err = os.Remove(recordPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Rename(data.Name(), dataPath)
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(recordPath+".tmp", jsonBytes, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(recordPath+".tmp", recordPath)
+}
+
+// Remove deletes a file's parity, if there is any.
+func (s *parityStore) Remove(relPath string) error {
+	for _, suffix := range []string{parityRecordSuffix, parityDataSuffix} {
+		err := os.Remove(s.filename(relPath, suffix))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParityUpdate counts the files whose parity was brought up to date.
+type ParityUpdate struct {
+	Generated int
+	Removed   int
+	// Files that changed between hashing and computing parity
+	Stale []string
+}
+
+// UpdateParity computes parity for files in the manifest without up-to-date
+// parity, and removes parity for files no longer in it. Parity is only ever
+// computed from content matching the manifest, so flagged files keep the
+// parity of their last known-good content. Files found at several paths
+// through symlinks only get parity at one of them.
+func UpdateParity(store *parityStore, manifest *Manifest, redundancy int) (*ParityUpdate, error) {
+	update := &ParityUpdate{}
+	aliases := manifest.linkAliases()
+	for relPath, entry := range manifest.Entries {
+		if _, ok := aliases[relPath]; ok {
+			continue
+		}
+		if entry.Size == 0 || entry.Checksum == "" || entry.unverified() {
+			continue
+		}
+		existing, err := store.Load(relPath)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil &&
+			existing.Algorithm == manifest.HashAlgorithm() &&
+			existing.Checksum == entry.Checksum &&
+			existing.Redundancy == redundancy {
+			continue
+		}
+		if entry.FlaggedSince != nil {
+			// The file's content doesn't match the manifest
+			continue
+		}
+		err = store.Generate(manifest.Path, relPath, entry, manifest.HashAlgorithm(), redundancy)
+		if err == errParityStale {
+			update.Stale = append(update.Stale, relPath)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		update.Generated++
+	}
+
+	files, err := filepath.Glob(filepath.Join(store.dir, "*"+parityRecordSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		relPath, err := parityRecordPath(file)
+		if err != nil {
+			return nil, err
+		}
+		_, tracked := manifest.Entries[relPath]
+		if _, ok := aliases[relPath]; !tracked || ok {
+			err = store.Remove(relPath)
+			if err != nil {
+				return nil, err
+			}
+			update.Removed++
+		}
+	}
+	return update, nil
+}
+
+func parityRecordPath(filename string) (string, error) {
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	var record ParityRecord
+	err = json.Unmarshal(jsonBytes, &record)
+	return record.Path, err
+}
+
+// Heal reconstructs a damaged file from its parity. Shards whose CRCs don't
+// match are treated as lost; each stripe can lose as many shards as it has
+// parity shards. The reconstructed file replaces the damaged one only if it
+// matches the manifest's checksum.
+func (s *parityStore) Heal(manifest *Manifest, relPath string, dryRun bool) error {
+	record, err := s.Load(relPath)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return fmt.Errorf("no parity for %s", relPath)
+	}
+	entry := manifest.Entries[relPath]
+	checksum, ok := entry.checksumFor(manifest.HashAlgorithm(), record.Algorithm)
+	if !ok || checksum != record.Checksum {
+		return fmt.Errorf("parity for %s doesn't match the last known-good checksum", relPath)
+	}
+
+	targetPath := realPath(filepath.Join(manifest.Path, relPath))
+	file, err := os.Open(targetPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	parityData, err := os.Open(s.filename(relPath, parityDataSuffix))
+	if err != nil {
+		return err
+	}
+	defer parityData.Close()
+
+	// Reconstruct stripe by stripe, writing the repaired content out
+	write := func(dest *os.File) error {
+		reader := io.LimitReader(file, record.Size)
+		remaining := record.Size
+		dataIndex, parityIndex := 0, 0
+		for _, stripe := range record.stripes() {
+			shards := make([][]byte, stripe.dataShards+stripe.parityShards)
+			lost := 0
+			for i := range shards {
+				buffer := make([]byte, record.ShardSize)
+				var expected uint32
+				if i < stripe.dataShards {
+					_, err = readShard(reader, buffer)
+					expected = record.DataCRCs[dataIndex+i]
+				} else {
+					_, err = readShard(parityData, buffer)
+					expected = record.ParityCRCs[parityIndex+i-stripe.dataShards]
+				}
+				if err != nil {
+					return err
+				}
+				if shardCRC(buffer) == expected {
+					shards[i] = buffer
+				} else {
+					lost++
+				}
+			}
+			if lost > stripe.parityShards {
+				return fmt.Errorf("%s has %d damaged shards in one stripe, more than its %d parity shards can repair", relPath, lost, stripe.parityShards)
+			}
+			if lost > 0 {
+				encoder, err := reedsolomon.New(stripe.dataShards, stripe.parityShards)
+				if err != nil {
+					return err
+				}
+				err = encoder.ReconstructData(shards)
+				if err != nil {
+					return err
+				}
+			}
+			for _, shard := range shards[:stripe.dataShards] {
+				if remaining < int64(len(shard)) {
+					shard = shard[:remaining]
+				}
+				_, err = dest.Write(shard)
+				if err != nil {
+					return err
+				}
+				remaining -= int64(len(shard))
+			}
+			dataIndex += stripe.dataShards
+			parityIndex += stripe.parityShards
+		}
+		return nil
+	}
+
+	if dryRun {
+		// Reconstruct without keeping the result, to check that it would work
+		temp, err := ioutil.TempFile("", "bitrot-heal-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(temp.Name())
+		defer temp.Close()
+		err = write(temp)
+		if err != nil {
+			return err
+		}
+		matches, err := matchesChecksum(temp.Name(), record.Algorithm, record.Checksum)
+		if err != nil {
+			return err
+		}
+		if !matches {
+			return fmt.Errorf("reconstructed %s doesn't match the last known-good checksum", relPath)
+		}
+		return nil
+	}
+	return replaceFile(targetPath, entry, record.Algorithm, record.Checksum, write)
+}
+
+func checkParityRedundancy(redundancy int) error {
+	if redundancy < 1 || redundancy > 100 {
+		return fmt.Errorf("parity redundancy must be between 1 and 100 percent, not %d", redundancy)
+	}
+	return nil
+}
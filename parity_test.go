@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParityStripes(t *testing.T) {
+	record := &ParityRecord{Size: 250*1000 + 1, ShardSize: 1000, Redundancy: 10}
+	assert.Equal(t, []parityStripe{
+		{dataShards: 100, parityShards: 10},
+		{dataShards: 100, parityShards: 10},
+		{dataShards: 51, parityShards: 6},
+	}, record.stripes())
+
+	// Always at least one parity shard
+	record = &ParityRecord{Size: 10, ShardSize: minParityShardSize, Redundancy: 1}
+	assert.Equal(t, []parityStripe{{dataShards: 1, parityShards: 1}}, record.stripes())
+
+	assert.Equal(t, minParityShardSize, parityShardSize(10))
+	assert.Equal(t, 10000, parityShardSize(1000000))
+	assert.Equal(t, maxParityShardSize, parityShardSize(1<<40))
+}
+
+// Sets up a directory with a random file, its manifest and parity.
+func parityTestSetup(t *testing.T, size int, redundancy int) (string, *Manifest, *parityStore, []byte) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	content := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(content)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "foo"), content, 0644))
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+
+	parityDir := filepath.Join(tempDir, ".parity")
+	assert.Nil(t, os.Mkdir(parityDir, 0755))
+	store := &parityStore{dir: parityDir}
+	update, err := UpdateParity(store, manifest, redundancy)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, update.Generated)
+	return tempDir, manifest, store, content
+}
+
+func corruptBytes(t *testing.T, path string, offsets ...int) {
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	for _, offset := range offsets {
+		content[offset] ^= 0xff
+	}
+	assert.Nil(t, ioutil.WriteFile(path, content, 0644))
+}
+
+func TestParityHeal(t *testing.T) {
+	tempDir, manifest, store, content := parityTestSetup(t, 1000*1000, 10)
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "foo")
+
+	// Up to date parity isn't regenerated
+	update, err := UpdateParity(store, manifest, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, update.Generated)
+
+	// Damage to 10 of the 100 shards (10000 bytes each) can be repaired
+	corruptBytes(t, path, 0, 1, 15000, 333333, 400000, 500000, 600000, 700000, 800000, 999999)
+	assert.Nil(t, store.Heal(manifest, "foo", true))
+	assert.Nil(t, store.Heal(manifest, "foo", false))
+	healed, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, content, healed)
+
+	// Parity can itself be damaged
+	parityPath := store.filename("foo", parityDataSuffix)
+	corruptBytes(t, parityPath, 0)
+	corruptBytes(t, path, 123456)
+	assert.Nil(t, store.Heal(manifest, "foo", false))
+	healed, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, content, healed)
+}
+
+func TestParityHealBeyondBudget(t *testing.T) {
+	tempDir, manifest, store, _ := parityTestSetup(t, 1000*1000, 1)
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "foo")
+
+	corruptBytes(t, path, 0, 500000)
+	damaged, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotNil(t, store.Heal(manifest, "foo", false))
+	unchanged, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, damaged, unchanged)
+}
+
+func TestParityHealTruncated(t *testing.T) {
+	tempDir, manifest, store, content := parityTestSetup(t, 100*1000, 5)
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "foo")
+
+	// Loses the last two of 25 shards
+	assert.Nil(t, os.Truncate(path, 97*1000))
+	assert.Nil(t, store.Heal(manifest, "foo", false))
+	healed, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, content, healed)
+}
+
+func TestGenerateParityForGrownFile(t *testing.T) {
+	tempDir, manifest, store, content := parityTestSetup(t, 1000, 10)
+	defer os.RemoveAll(tempDir)
+
+	// Same content up to the recorded size
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "foo"), append(content, "more"...), 0644))
+	err := store.Generate(tempDir, "foo", manifest.Entries["foo"], manifest.HashAlgorithm(), 10)
+	assert.Equal(t, errParityStale, err)
+}
+
+func TestUpdateParityRemovesDeletedFiles(t *testing.T) {
+	tempDir, manifest, store, _ := parityTestSetup(t, 1000, 10)
+	defer os.RemoveAll(tempDir)
+
+	delete(manifest.Entries, "foo")
+	update, err := UpdateParity(store, manifest, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, update.Removed)
+	record, err := store.Load("foo")
+	assert.Nil(t, err)
+	assert.Nil(t, record)
+}
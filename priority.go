@@ -0,0 +1,18 @@
+package main
+
+import "fmt"
+
+// I/O scheduling classes a run can lower itself to
+const (
+	// Only read when no other process wants the disk
+	ioPriorityIdle = "idle"
+	// The lowest priority among normal processes
+	ioPriorityBestEffort = "best-effort"
+)
+
+func checkIOPriority(priority string) error {
+	if priority != "" && priority != ioPriorityIdle && priority != ioPriorityBestEffort {
+		return fmt.Errorf("I/O priority must be %q or %q, not %q", ioPriorityIdle, ioPriorityBestEffort, priority)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// From linux/ioprio.h
+const (
+	ioprioWhoProcess    = 1
+	ioprioClassShift    = 13
+	ioprioClassBE       = 2
+	ioprioClassIdle     = 3
+	ioprioLowestBELevel = 7
+)
+
+// setPriority lowers the process's I/O scheduling class (if ioPriority is set)
+// and sets its niceness (if nice is nonzero). Linux applies both to each
+// thread, so every current thread is changed; threads started later inherit
+// them from the thread that starts them.
+func setPriority(ioPriority string, nice int) error {
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	ioprio := ioprioClassBE<<ioprioClassShift | ioprioLowestBELevel
+	if ioPriority == ioPriorityIdle {
+		ioprio = ioprioClassIdle << ioprioClassShift
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if ioPriority != "" {
+			_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio))
+			// Threads may exit while the list is being walked
+			if errno != 0 && errno != unix.ESRCH {
+				return errno
+			}
+		}
+		if nice != 0 {
+			err = unix.Setpriority(unix.PRIO_PROCESS, tid, nice)
+			if err != nil && err != unix.ESRCH {
+				return err
+			}
+		}
+	}
+	return nil
+}
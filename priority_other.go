@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// setPriority would lower the process's I/O scheduling class and set its
+// niceness, but this is only supported on Linux.
+func setPriority(ioPriority string, nice int) error {
+	if ioPriority != "" || nice != 0 {
+		return fmt.Errorf("setting I/O priority and niceness is only supported on Linux")
+	}
+	return nil
+}
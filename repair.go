@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Why a file needs repair
+const (
+	repairReasonFlagged = "flagged"
+	repairReasonDeleted = "deleted"
+)
+
+// RepairResult is the outcome of restoring one file from a replica.
+type RepairResult struct {
+	Path   string
+	Reason string
+	// Set if the file wasn't (or in a dry run, couldn't be) restored
+	Err error
+}
+
+// knownGoodChecksum returns a checksum recorded for a manifest entry and its
+// algorithm, preferring the primary algorithm.
+func knownGoodChecksum(manifest *Manifest, relPath string) (string, string, bool) {
+	entry, ok := manifest.Entries[relPath]
+	if !ok {
+		return "", "", false
+	}
+	// The primary checksum is empty if the file was flagged while migrating
+	// algorithms
+	if entry.Checksum != "" {
+		return manifest.HashAlgorithm(), entry.Checksum, true
+	}
+	for _, algorithm := range manifest.AltAlgorithms {
+		if sum, ok := entry.AltChecksums[algorithm]; ok {
+			return algorithm, sum, true
+		}
+	}
+	return "", "", false
+}
+
+// matchesChecksum reports whether a file's content has the checksum.
+func matchesChecksum(path, algorithm, checksum string) (bool, error) {
+	sums, err := generateChecksums(path, []string{algorithm})
+	if err != nil {
+		return false, err
+	}
+	return sums[0] == checksum, nil
+}
+
+// RestoreFile replaces a file under the manifest's path with its copy under
+// replicaRoot, if the copy matches the checksum in the manifest. If the
+// manifest has chunk hashes, only damaged chunks are copied. The result is
+// written to a temporary file and verified before being renamed into place,
+// so the file is never replaced by content that doesn't match. The damaged
+// file is read in the cache mode if set. Nothing is written in a dry run.
+func RestoreFile(manifest *Manifest, relPath, replicaRoot, cacheMode string, dryRun bool) error {
+	algorithm, checksum, ok := knownGoodChecksum(manifest, relPath)
+	if !ok {
+		return fmt.Errorf("no known-good checksum for %s", relPath)
+	}
+	entry := manifest.Entries[relPath]
+	targetPath := realPath(filepath.Join(manifest.Path, relPath))
+	replicaPath := filepath.Join(replicaRoot, relPath)
+
+	if !dryRun {
+		// Copy only the damaged chunks if they're known; the result is
+		// verified, and otherwise the whole file is copied below
+		ranges, err := damagedRanges(manifest, relPath, cacheMode)
+		if err != nil {
+			return err
+		}
+		if len(ranges) > 0 {
+			err = replaceFile(targetPath, entry, algorithm, checksum, func(dest *os.File) error {
+				return patchFileContents(dest, targetPath, replicaPath, ranges)
+			})
+			if err == nil {
+				return nil
+			}
+		}
+	}
+
+	matches, err := matchesChecksum(replicaPath, algorithm, checksum)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return fmt.Errorf("%s doesn't match the last known-good checksum", replicaPath)
+	}
+	if dryRun {
+		return nil
+	}
+
+	return replaceFile(targetPath, entry, algorithm, checksum, func(dest *os.File) error {
+		return copyFileContents(dest, replicaPath)
+	})
+}
+
+// damagedRanges returns the byte ranges of a file whose chunk hashes no longer
+// match the manifest, or nil if they can't be compared.
+func damagedRanges(manifest *Manifest, relPath, cacheMode string) ([]ByteRange, error) {
+	entry := manifest.Entries[relPath]
+	if manifest.ChunkSize == 0 || entry.Chunks == nil {
+		return nil, nil
+	}
+	path := filepath.Join(manifest.Path, relPath)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Only damage in place is patched
+	if info.Size() != entry.Size {
+		return nil, nil
+	}
+	_, chunks, err := hashFile(path, nil, hashOptions{chunkSize: manifest.ChunkSize, cacheMode: cacheMode})
+	if err != nil {
+		return nil, err
+	}
+	return changedRanges(entry.Chunks, chunks, manifest.ChunkSize, entry.Size, info.Size()), nil
+}
+
+// replaceFile writes a replacement for a file to a temporary file beside it,
+// restoring the mode and modification time from entry, and renames it into
+// place only if it has the checksum.
+func replaceFile(targetPath string, entry ChecksumRecord, algorithm, checksum string, write func(*os.File) error) error {
+	targetDir := filepath.Dir(targetPath)
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(targetDir, "."+filepath.Base(targetPath)+".bitrot-repair-")
+	if err != nil {
+		return err
+	}
+	tempPath := temp.Name()
+	// Removes the temporary file if it wasn't renamed into place
+	defer os.Remove(tempPath)
+
+	err = write(temp)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if err != nil {
+		temp.Close()
+		return err
+	}
+	err = temp.Close()
+	if err != nil {
+		return err
+	}
+	// Metadata is recorded along with the mode; ownership goes first, since
+	// changing it can clear setuid bits
+	if entry.Mode != 0 {
+		err = restoreOwner(tempPath, entry.Uid, entry.Gid)
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(tempPath, entry.Mode.Perm())
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Chtimes(tempPath, entry.ModTime, entry.ModTime)
+	if err != nil {
+		return err
+	}
+
+	// Re-read what was written before replacing anything
+	matches, err := matchesChecksum(tempPath, algorithm, checksum)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return fmt.Errorf("replacement for %s doesn't match the last known-good checksum after writing", targetPath)
+	}
+	return os.Rename(tempPath, targetPath)
+}
+
+// Copies a file's contents into an open file.
+func copyFileContents(dest *os.File, sourcePath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	_, err = io.Copy(dest, source)
+	return err
+}
+
+// Copies a file's contents into an open file, replacing the given ranges with
+// those from another file.
+func patchFileContents(dest *os.File, basePath, patchPath string, ranges []ByteRange) error {
+	err := copyFileContents(dest, basePath)
+	if err != nil {
+		return err
+	}
+	patch, err := os.Open(patchPath)
+	if err != nil {
+		return err
+	}
+	defer patch.Close()
+	for _, r := range ranges {
+		_, err = dest.Seek(r.Start, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(dest, io.NewSectionReader(patch, r.Start, r.Len()))
+		if err != nil {
+			return err
+		}
+		if n != r.Len() {
+			return fmt.Errorf("%s is too short to patch bytes %s", patchPath, r)
+		}
+	}
+	return nil
+}
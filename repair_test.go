@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	replicaDir, err := ioutil.TempDir("", "replica")
+	assert.Nil(t, err)
+	defer os.RemoveAll(replicaDir)
+
+	path := writeTestFile(t, tempDir, "foo", helloWorldString)
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, os.Chtimes(path, modTime, modTime))
+	writeTestFile(t, replicaDir, "foo", helloWorldString)
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+	writeTestFile(t, tempDir, "foo", "corrupted!!!\n")
+
+	// Dry run leaves the file alone
+	assert.Nil(t, RestoreFile(manifest, "foo", replicaDir, "", true))
+	contents, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "corrupted!!!\n", string(contents))
+
+	assert.Nil(t, RestoreFile(manifest, "foo", replicaDir, "", false))
+	contents, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, helloWorldString, string(contents))
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.True(t, info.ModTime().Equal(modTime))
+
+	// No temporary files left behind
+	files, err := ioutil.ReadDir(tempDir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestRestoreFileReplicaMismatch(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	replicaDir, err := ioutil.TempDir("", "replica")
+	assert.Nil(t, err)
+	defer os.RemoveAll(replicaDir)
+
+	writeTestFile(t, tempDir, "foo", helloWorldString)
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+	writeTestFile(t, tempDir, "foo", "corrupted!!!\n")
+	writeTestFile(t, replicaDir, "foo", "also bad!!!!\n")
+
+	assert.NotNil(t, RestoreFile(manifest, "foo", replicaDir, "", false))
+	contents, err := ioutil.ReadFile(filepath.Join(tempDir, "foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, "corrupted!!!\n", string(contents))
+
+	// Missing from the replica
+	assert.Nil(t, os.Remove(filepath.Join(replicaDir, "foo")))
+	assert.NotNil(t, RestoreFile(manifest, "foo", replicaDir, "", false))
+}
+
+func TestRestoreFileCopiesOnlyDamagedChunks(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	replicaDir, err := ioutil.TempDir("", "replica")
+	assert.Nil(t, err)
+	defer os.RemoveAll(replicaDir)
+
+	content := []byte(strings.Repeat("0123456789", 100))
+	path := writeTestFile(t, tempDir, "foo", string(content))
+	manifest, err := NewManifest(tempDir, &Config{ChunkSize: 100})
+	assert.Nil(t, err)
+	assert.Len(t, manifest.Entries["foo"].Chunks, 10)
+
+	// Damage in chunk 2; the replica is damaged in chunk 7 only
+	damaged := append([]byte{}, content...)
+	damaged[250] = 'x'
+	assert.Nil(t, ioutil.WriteFile(path, damaged, 0644))
+	replica := append([]byte{}, content...)
+	replica[750] = 'x'
+	writeTestFile(t, replicaDir, "foo", string(replica))
+
+	ranges, err := damagedRanges(manifest, "foo", cacheModeEvict)
+	assert.Nil(t, err)
+	assert.Equal(t, []ByteRange{{200, 300}}, ranges)
+
+	// A dry run checks the whole replica
+	assert.NotNil(t, RestoreFile(manifest, "foo", replicaDir, "", true))
+	assert.Nil(t, RestoreFile(manifest, "foo", replicaDir, "", false))
+	restored, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, content, restored)
+}
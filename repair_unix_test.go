@@ -0,0 +1,43 @@
+//go:build unix
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreFileOwnership(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	replicaDir, err := ioutil.TempDir("", "replica")
+	assert.Nil(t, err)
+	defer os.RemoveAll(replicaDir)
+
+	writeTestFile(t, tempDir, "foo", helloWorldString)
+	writeTestFile(t, replicaDir, "foo", helloWorldString)
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+	entry := manifest.Entries["foo"]
+	entry.Uid, entry.Gid = 1234, 5678
+	manifest.Entries["foo"] = entry
+	writeTestFile(t, tempDir, "foo", "corrupted!!!\n")
+
+	// Without root the owner can't be changed, which doesn't stop the repair
+	assert.Nil(t, RestoreFile(manifest, "foo", replicaDir, "", false))
+	info, err := os.Stat(filepath.Join(tempDir, "foo"))
+	assert.Nil(t, err)
+	stat := info.Sys().(*syscall.Stat_t)
+	if os.Geteuid() == 0 {
+		assert.Equal(t, uint32(1234), stat.Uid)
+		assert.Equal(t, uint32(5678), stat.Gid)
+	} else {
+		assert.Equal(t, uint32(os.Geteuid()), stat.Uid)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Times to re-read flagged files when not configured
+const defaultRereads = 2
+
+// Rereads holds the results of re-reading a flagged file to confirm that it
+// really changed.
+type Rereads struct {
+	// Checksums from each re-read, empty where a read failed
+	Checksums []string
+	// Errors from failed reads
+	Errors []error
+	// Whether every re-read matched the checksum that flagged the file
+	Persistent bool
+}
+
+// String describes the outcome of the re-reads.
+func (r *Rereads) String() string {
+	if r.Persistent {
+		return "persistently different"
+	}
+	distinct := map[string]bool{}
+	for _, checksum := range r.Checksums {
+		if checksum != "" {
+			distinct[checksum] = true
+		}
+	}
+	s := fmt.Sprintf("inconsistent reads: %d re-reads gave %d different checksums", len(r.Checksums), len(distinct))
+	if len(r.Errors) > 0 {
+		s += fmt.Sprintf(" and %d errors (%s)", len(r.Errors), r.Errors[0])
+	}
+	return s
+}
+
+// rereadFile re-hashes a file the given number of times, evicting it from the
+// cache around each read where possible, and compares each result with the
+// checksum it was flagged with.
+func rereadFile(path, algorithm, checksum string, times int) *Rereads {
+	rereads := &Rereads{Persistent: true}
+	for i := 0; i < times; i++ {
+		sums, _, err := hashFile(path, []string{algorithm}, hashOptions{cacheMode: cacheModeEvict})
+		if err != nil {
+			rereads.Checksums = append(rereads.Checksums, "")
+			rereads.Errors = append(rereads.Errors, err)
+			rereads.Persistent = false
+			continue
+		}
+		rereads.Checksums = append(rereads.Checksums, sums[0])
+		if sums[0] != checksum {
+			rereads.Persistent = false
+		}
+	}
+	return rereads
+}
+
+// ConfirmFlagged re-reads each flagged file the given number of times before
+// it's reported. Files read differently across reads point at hardware
+// trouble rather than corrupted data.
+func (comp *ManifestComparison) ConfirmFlagged(times int) {
+	if times <= 0 {
+		return
+	}
+	comp.rereads = map[string]*Rereads{}
+	for _, path := range comp.FlaggedPaths {
+		comp.rereads[path] = rereadFile(
+			filepath.Join(comp.newManifest.Path, path),
+			comp.newManifest.HashAlgorithm(),
+			comp.newManifest.Entries[path].Checksum,
+			times,
+		)
+	}
+}
+
+// Rereads returns the results of re-reading a flagged path, or nil if it
+// wasn't re-read.
+func (comp *ManifestComparison) Rereads(path string) *Rereads {
+	return comp.rereads[path]
+}
+
+// InconsistentPaths lists flagged paths whose re-reads didn't all match.
+func (comp *ManifestComparison) InconsistentPaths() []string {
+	paths := []string{}
+	for _, path := range comp.FlaggedPaths {
+		if rereads := comp.rereads[path]; rereads != nil && !rereads.Persistent {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
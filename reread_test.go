@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirmFlagged(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "reread")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "persistent"), []byte("corrupted"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "inconsistent"), []byte("fine"), 0644))
+	corrupted, err := generateChecksums(filepath.Join(tempDir, "persistent"), []string{"sha1"})
+	assert.Nil(t, err)
+
+	modTime := time.Now()
+	oldManifest := &Manifest{
+		Path: tempDir,
+		Entries: map[string]ChecksumRecord{
+			"persistent":   {Checksum: "original", ModTime: modTime},
+			"inconsistent": {Checksum: "original", ModTime: modTime},
+			"vanished":     {Checksum: "original", ModTime: modTime},
+		},
+	}
+	newManifest := &Manifest{
+		Path: tempDir,
+		Entries: map[string]ChecksumRecord{
+			"persistent": {Checksum: corrupted[0], ModTime: modTime},
+			// As if the first read returned bad data
+			"inconsistent": {Checksum: "badread", ModTime: modTime},
+			"vanished":     {Checksum: "badread", ModTime: modTime},
+		},
+	}
+	comparison := CompareManifests(oldManifest, newManifest)
+	assert.ElementsMatch(t, []string{"persistent", "inconsistent", "vanished"}, comparison.FlaggedPaths)
+	assert.Nil(t, comparison.Rereads("persistent"))
+
+	comparison.ConfirmFlagged(3)
+	assert.True(t, comparison.Rereads("persistent").Persistent)
+	assert.Equal(t, []string{corrupted[0], corrupted[0], corrupted[0]}, comparison.Rereads("persistent").Checksums)
+	assert.Equal(t, "persistently different", comparison.Rereads("persistent").String())
+	assert.False(t, comparison.Rereads("inconsistent").Persistent)
+	assert.Equal(t, "inconsistent reads: 3 re-reads gave 1 different checksums", comparison.Rereads("inconsistent").String())
+	assert.Len(t, comparison.Rereads("vanished").Errors, 3)
+	assert.ElementsMatch(t, []string{"inconsistent", "vanished"}, comparison.InconsistentPaths())
+
+	report := NewComparisonReport(comparison)
+	assert.Contains(t, report.SummaryString(), "Flagged paths: 3 (0 B)\n    1 persistently different, 2 with inconsistent reads\n")
+}
+
+func TestRereadCount(t *testing.T) {
+	assert.Equal(t, defaultRereads, rereadCount(0, RootConfig{}))
+	assert.Equal(t, 5, rereadCount(0, RootConfig{Rereads: 5}))
+	assert.Equal(t, 1, rereadCount(1, RootConfig{Rereads: 5}))
+	assert.Equal(t, -1, rereadCount(-1, RootConfig{}))
+}
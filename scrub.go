@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ScrubBudget limits how much of a manifest a scrub verifies. Zero values are
+// unlimited, but at least one file is always verified.
+type ScrubBudget struct {
+	MaxDuration time.Duration
+	MaxBytes    ByteSize
+}
+
+// ScrubResult summarizes a scrub of the least recently verified files in a
+// manifest.
+type ScrubResult struct {
+	// Comparison of the scrubbed entries against their stored checksums
+	Comparison *ManifestComparison
+	// Copy of the scrubbed manifest with verified entries updated
+	Manifest *Manifest
+	Files    int
+	Bytes    ByteSize
+	Duration time.Duration
+}
+
+// ScrubManifest re-hashes the files in a manifest that were verified longest ago,
+// until the budget is used up, reading them in the cache mode if set.
+func ScrubManifest(baseline *Manifest, budget ScrubBudget, cacheMode string) (*ScrubResult, error) {
+	start := time.Now()
+	algorithms := append([]string{baseline.HashAlgorithm()}, baseline.AltAlgorithms...)
+	checked := &Manifest{
+		Path:          baseline.Path,
+		CreatedAt:     baseline.CreatedAt,
+		Algorithm:     baseline.Algorithm,
+		AltAlgorithms: baseline.AltAlgorithms,
+		ChunkSize:     baseline.ChunkSize,
+		Excludes:      baseline.Excludes,
+		Entries:       map[string]ChecksumRecord{},
+	}
+	current := &Manifest{
+		Path:          baseline.Path,
+		CreatedAt:     start.UTC(),
+		Algorithm:     baseline.Algorithm,
+		AltAlgorithms: baseline.AltAlgorithms,
+		ChunkSize:     baseline.ChunkSize,
+		Excludes:      baseline.Excludes,
+		Entries:       map[string]ChecksumRecord{},
+	}
+	result := &ScrubResult{}
+	// Budgeted by recorded sizes, so deleted files count too
+	var budgeted ByteSize
+
+	for _, relPath := range leastRecentlyVerified(baseline) {
+		entry := baseline.Entries[relPath]
+		if result.Files > 0 {
+			if budget.MaxDuration > 0 && time.Since(start) >= budget.MaxDuration {
+				break
+			}
+			if budget.MaxBytes > 0 && budgeted+ByteSize(entry.Size) > budget.MaxBytes {
+				break
+			}
+		}
+		budgeted += ByteSize(entry.Size)
+
+		checked.Entries[relPath] = entry
+		result.Files++
+		path := filepath.Join(baseline.Path, relPath)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			// Reported as deleted
+			continue
+		}
+		if err != nil {
+			current.Entries[relPath] = unreadableRecord(nil, err)
+			continue
+		}
+		checksums, chunks, err := hashFile(path, algorithms, hashOptions{chunkSize: baseline.ChunkSize, cacheMode: cacheMode})
+		if err != nil {
+			// Reported as unreadable, keeping the entry as it was
+			current.Entries[relPath] = unreadableRecord(info, err)
+			continue
+		}
+		record := newChecksumRecord(info, algorithms, checksums)
+		record.Chunks = chunks
+		record.LinkTarget = entry.LinkTarget
+		record.LastVerified = time.Now().UTC()
+		current.Entries[relPath] = record
+		result.Bytes += ByteSize(info.Size())
+	}
+
+	result.Comparison = CompareManifests(checked, current)
+	result.Manifest = scrubbedManifest(baseline, result.Comparison, current)
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// Overdue counts entries not verified within the cycle as of now, out of those
+// a scrub verifies.
+func (result *ScrubResult) Overdue(cycle time.Duration) int {
+	cutoff := time.Now().Add(-cycle)
+	count := 0
+	for _, entry := range result.Manifest.Entries {
+		if entry.scrubbable() && entry.LastVerified.Before(cutoff) {
+			count++
+		}
+	}
+	return count
+}
+
+// ScrubBytesPerRun returns the number of bytes each daily scrub must
+// verify to cover the whole manifest once per cycle.
+func ScrubBytesPerRun(manifest *Manifest, cycle time.Duration) ByteSize {
+	var total int64
+	for _, entry := range manifest.Entries {
+		total += entry.Size
+	}
+	runs := int64(cycle / (24 * time.Hour))
+	if runs < 1 {
+		return ByteSize(total)
+	}
+	return ByteSize((total + runs - 1) / runs)
+}
+
+// scrubbable reports whether a record has content to verify: symlinks that
+// weren't followed and files never read don't.
+func (r *ChecksumRecord) scrubbable() bool {
+	return !r.linkOnly() && !r.neverRead()
+}
+
+// Oldest verification first; entries from before verification times were
+// recorded sort first. Only scrubbable entries are included.
+func leastRecentlyVerified(manifest *Manifest) []string {
+	paths := []string{}
+	for relPath, entry := range manifest.Entries {
+		if entry.scrubbable() {
+			paths = append(paths, relPath)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		a := manifest.Entries[paths[i]].LastVerified
+		b := manifest.Entries[paths[j]].LastVerified
+		if a.Equal(b) {
+			return paths[i] < paths[j]
+		}
+		return a.Before(b)
+	})
+	return paths
+}
+
+// Builds the manifest to store after a scrub. Flagged entries keep their
+// known-good checksum so they are flagged (and scrubbed first) again next time.
+func scrubbedManifest(baseline *Manifest, comparison *ManifestComparison, current *Manifest) *Manifest {
+	manifest := &Manifest{
+		Path:          baseline.Path,
+		CreatedAt:     current.CreatedAt,
+		Algorithm:     baseline.Algorithm,
+		AltAlgorithms: baseline.AltAlgorithms,
+		ChunkSize:     baseline.ChunkSize,
+		Excludes:      baseline.Excludes,
+		Entries:       map[string]ChecksumRecord{},
+	}
+	for relPath, entry := range baseline.Entries {
+		manifest.Entries[relPath] = entry
+	}
+	for _, relPath := range comparison.DeletedPaths {
+		delete(manifest.Entries, relPath)
+	}
+	verified := append(append([]string{}, comparison.UnchangedPaths...), comparison.ModifiedPaths...)
+	for _, relPath := range append(verified, comparison.MetadataChangedPaths...) {
+		manifest.Entries[relPath] = current.Entries[relPath]
+	}
+	manifest.KeepKnownGood(baseline, comparison.FlaggedPaths)
+	return manifest
+}
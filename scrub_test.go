@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrubManifest(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, tempDir, "oldest", helloWorldString)
+	writeTestFile(t, tempDir, "older", helloWorldString)
+	writeTestFile(t, tempDir, "newest", helloWorldString)
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+	for relPath, age := range map[string]time.Duration{"oldest": 72 * time.Hour, "older": 48 * time.Hour, "newest": time.Hour} {
+		entry := manifest.Entries[relPath]
+		entry.LastVerified = time.Now().Add(-age).UTC()
+		manifest.Entries[relPath] = entry
+	}
+	assert.Equal(t, []string{"oldest", "older", "newest"}, leastRecentlyVerified(manifest))
+
+	// Corrupt one file and delete another
+	oldestPath := filepath.Join(tempDir, "oldest")
+	stat, err := os.Stat(oldestPath)
+	assert.Nil(t, err)
+	writeTestFile(t, tempDir, "oldest", "corrupted!!!\n")
+	assert.Nil(t, os.Chtimes(oldestPath, stat.ModTime(), stat.ModTime()))
+	assert.Nil(t, os.Remove(filepath.Join(tempDir, "older")))
+
+	// Budget covers two files
+	result, err := ScrubManifest(manifest, ScrubBudget{MaxBytes: ByteSize(2 * len(helloWorldString))}, cacheModeEvict)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Files)
+	assert.Equal(t, ByteSize(len(helloWorldString)), result.Bytes)
+	assert.Equal(t, []string{"oldest"}, result.Comparison.FlaggedPaths)
+	assert.Equal(t, []string{"older"}, result.Comparison.DeletedPaths)
+	assert.Equal(t, 2, result.Comparison.TotalChecked())
+
+	// Flagged entry keeps its stored checksum and is marked; deleted entry is
+	// dropped
+	flagged := result.Manifest.Entries["oldest"]
+	assert.Equal(t, manifest.Entries["oldest"].Checksum, flagged.Checksum)
+	assert.Equal(t, manifest.Entries["oldest"].LastVerified, flagged.LastVerified)
+	if assert.NotNil(t, flagged.FlaggedSince) {
+		assert.Equal(t, result.Manifest.CreatedAt, *flagged.FlaggedSince)
+	}
+	assert.NotContains(t, result.Manifest.Entries, "older")
+	assert.Equal(t, manifest.Entries["newest"], result.Manifest.Entries["newest"])
+	assert.Equal(t, 1, result.Overdue(24*time.Hour))
+
+	// Entries without content to verify are never overdue
+	result.Manifest.Entries["link"] = ChecksumRecord{LinkTarget: "newest"}
+	result.Manifest.Entries["unread"] = ChecksumRecord{Unreadable: "permission denied"}
+	assert.Equal(t, 1, result.Overdue(24*time.Hour))
+}
+
+func TestScrubManifestVerifiesAtLeastOneFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, tempDir, "foo", helloWorldString)
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+
+	result, err := ScrubManifest(manifest, ScrubBudget{MaxBytes: 1}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Files)
+	assert.Equal(t, []string{"foo"}, result.Comparison.UnchangedPaths)
+	assert.True(t, result.Manifest.Entries["foo"].LastVerified.After(manifest.Entries["foo"].LastVerified))
+}
+
+func TestScrubManifestRecordsMetadataChanges(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, tempDir, "foo", helloWorldString)
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chmod(filepath.Join(tempDir, "foo"), 0600))
+
+	result, err := ScrubManifest(manifest, ScrubBudget{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, result.Comparison.MetadataChangedPaths)
+	// Verified, and the new metadata becomes the baseline
+	entry := result.Manifest.Entries["foo"]
+	assert.Equal(t, os.FileMode(0600), entry.Mode)
+	assert.True(t, entry.LastVerified.After(manifest.Entries["foo"].LastVerified))
+}
+
+func TestScrubManifestContinuesPastUnreadableFiles(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, tempDir, "foo", helloWorldString)
+	writeTestFile(t, tempDir, "bar", helloWorldString)
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+	// Never read, so there's nothing to verify
+	manifest.Entries["never"] = ChecksumRecord{Unreadable: "open: permission denied"}
+	assert.Equal(t, []string{"bar", "foo"}, leastRecentlyVerified(manifest))
+	// A directory in place of a file can't be hashed
+	assert.Nil(t, os.Remove(filepath.Join(tempDir, "bar")))
+	assert.Nil(t, os.Mkdir(filepath.Join(tempDir, "bar"), 0755))
+
+	result, err := ScrubManifest(manifest, ScrubBudget{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Files)
+	assert.Equal(t, []string{"bar"}, result.Comparison.UnreadablePaths)
+	assert.Equal(t, []string{"foo"}, result.Comparison.UnchangedPaths)
+	// Unreadable entries are kept as they were
+	assert.Equal(t, manifest.Entries["bar"], result.Manifest.Entries["bar"])
+	assert.Equal(t, manifest.Entries["never"], result.Manifest.Entries["never"])
+}
+
+func TestScrubManifestDuringHashMigration(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, tempDir, "foo", helloWorldString)
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+	// Flagged while migrating to sha256, so only the sha1 checksum is known
+	manifest.Algorithm = "sha256"
+	manifest.AltAlgorithms = []string{"sha1"}
+	flaggedSince := time.Now().UTC()
+	entry := manifest.Entries["foo"]
+	entry.AltChecksums = map[string]string{"sha1": entry.Checksum}
+	entry.Checksum = ""
+	entry.FlaggedSince = &flaggedSince
+	manifest.Entries["foo"] = entry
+
+	result, err := ScrubManifest(manifest, ScrubBudget{}, "")
+	assert.Nil(t, err)
+	assert.Empty(t, result.Comparison.FlaggedPaths)
+	assert.Equal(t, []string{"foo"}, result.Comparison.UnchangedPaths)
+	assert.Nil(t, result.Manifest.Entries["foo"].FlaggedSince)
+}
+
+func TestScrubBytesPerRun(t *testing.T) {
+	manifest := &Manifest{Entries: map[string]ChecksumRecord{
+		"a": {Size: 100},
+		"b": {Size: 201},
+	}}
+	assert.Equal(t, ByteSize(11), ScrubBytesPerRun(manifest, 30*24*time.Hour))
+	assert.Equal(t, ByteSize(301), ScrubBytesPerRun(manifest, time.Hour))
+}
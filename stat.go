@@ -0,0 +1,16 @@
+package main
+
+import (
+	"time"
+)
+
+// fileStat holds file metadata beyond what os.FileInfo provides portably.
+// Fields are zero where the platform doesn't support them.
+type fileStat struct {
+	Device uint64
+	Inode  uint64
+	CTime  time.Time
+	Uid    uint32
+	Gid    uint32
+	Nlink  uint64
+}
@@ -0,0 +1,12 @@
+//go:build linux || openbsd || dragonfly || solaris || illumos
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+func statCTime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec)).UTC()
+}
@@ -0,0 +1,13 @@
+//go:build unix && !(linux || openbsd || dragonfly || solaris || illumos || darwin || freebsd || netbsd)
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// Change time isn't available in a known field on this platform.
+func statCTime(stat *syscall.Stat_t) time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,12 @@
+//go:build darwin || freebsd || netbsd
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+func statCTime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Ctimespec.Sec), int64(stat.Ctimespec.Nsec)).UTC()
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// Devices, inodes, change times and ownership aren't available on this
+// platform.
+func statFromInfo(info os.FileInfo) fileStat {
+	return fileStat{}
+}
+
+// Ownership isn't recorded on this platform, so there's none to restore.
+func restoreOwner(path string, uid, gid uint32) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func statFromInfo(info os.FileInfo) fileStat {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileStat{}
+	}
+	return fileStat{
+		Device: uint64(stat.Dev),
+		Inode:  uint64(stat.Ino),
+		CTime:  statCTime(stat),
+		Uid:    stat.Uid,
+		Gid:    stat.Gid,
+		Nlink:  uint64(stat.Nlink),
+	}
+}
+
+// restoreOwner sets a file's owner and group. Only root can give files away, so
+// a lack of permission isn't an error.
+func restoreOwner(path string, uid, gid uint32) error {
+	err := os.Lchown(path, int(uid), int(gid))
+	if errors.Is(err, syscall.EPERM) {
+		return nil
+	}
+	return err
+}
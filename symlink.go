@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// fileID identifies a file or directory by device and inode.
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+func fileIDFromInfo(info os.FileInfo) fileID {
+	stat := statFromInfo(info)
+	return fileID{device: stat.Device, inode: stat.Inode}
+}
+
+// known reports whether the platform gave the file an inode, without which
+// files can't be told apart.
+func (id fileID) known() bool {
+	return id.inode != 0
+}
+
+// fileAlias is another path to a file already found while following symlinks.
+type fileAlias struct {
+	// Relative path the file was first found at, and hashed for
+	path string
+	// Target of the symlink at the alias, if it is one
+	linkTarget string
+}
+
+// Returns a record for a symlink that isn't followed.
+func linkRecord(info os.FileInfo, target string) ChecksumRecord {
+	stat := statFromInfo(info)
+	return ChecksumRecord{
+		LinkTarget: target,
+		ModTime:    info.ModTime().UTC(),
+		Mode:       info.Mode(),
+		Uid:        stat.Uid,
+		Gid:        stat.Gid,
+	}
+}
+
+// isLink reports whether a record is for a symlink.
+func (r *ChecksumRecord) isLink() bool {
+	return r.LinkTarget != ""
+}
+
+// linkOnly reports whether a record is for a symlink whose target wasn't
+// hashed, so has no content to check.
+func (r *ChecksumRecord) linkOnly() bool {
+	return r.isLink() && r.Checksum == ""
+}
+
+// followedSymlinks reports whether a manifest was made following symlinks:
+// whether a link's target was hashed or walked.
+func (m *Manifest) followedSymlinks() bool {
+	links := map[string]bool{}
+	for relPath, entry := range m.Entries {
+		if entry.isLink() {
+			if !entry.linkOnly() {
+				return true
+			}
+			links[relPath] = true
+		}
+	}
+	if len(links) == 0 {
+		return false
+	}
+	for relPath := range m.Entries {
+		for dir := filepath.Dir(relPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			if links[dir] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// realPath resolves symlinks in a path, so that a file found through links is
+// replaced where it actually is rather than over a link. A path that doesn't
+// resolve, e.g. to a deleted file, is returned as is.
+func realPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// linkAliases returns the entries that reach a file through symlinks when
+// another entry is for the same file, mapped to that entry, so that a file is
+// repaired or given parity once. The entry at the file's own path is preferred.
+// Only manifests made following symlinks have aliases.
+func (m *Manifest) linkAliases() map[string]string {
+	aliases := map[string]string{}
+	relPaths := []string{}
+	hasLinks := false
+	for relPath, entry := range m.Entries {
+		hasLinks = hasLinks || entry.isLink()
+		if !entry.linkOnly() {
+			relPaths = append(relPaths, relPath)
+		}
+	}
+	if !hasLinks {
+		return aliases
+	}
+	sort.Strings(relPaths)
+
+	root := realPath(m.Path)
+	// Entry chosen for each real file
+	chosen := map[string]string{}
+	for _, relPath := range relPaths {
+		real := realPath(filepath.Join(m.Path, relPath))
+		first, ok := chosen[real]
+		if !ok {
+			chosen[real] = relPath
+			continue
+		}
+		if real == filepath.Join(root, relPath) {
+			aliases[first] = relPath
+			chosen[real] = relPath
+		} else {
+			aliases[relPath] = first
+		}
+	}
+	// Point every alias at the chosen entry
+	for alias := range aliases {
+		aliases[alias] = chosen[realPath(filepath.Join(m.Path, alias))]
+	}
+	return aliases
+}
+
+// aliasesHandled returns the paths among candidates that are aliases of files
+// in handled, so were taken care of along with them.
+func aliasesHandled(candidates, handled []string, aliases map[string]string) []string {
+	paths := []string{}
+	for _, candidate := range candidates {
+		if realEntry, ok := aliases[candidate]; ok && containsString(handled, realEntry) {
+			paths = append(paths, candidate)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Makes a tree with symlinks to a file, a directory, an ancestor directory, and
+// nothing.
+func setupSymlinkTree(t *testing.T) string {
+	tempDir, err := ioutil.TempDir("", "symlink")
+	assert.Nil(t, err)
+	assert.Nil(t, os.Mkdir(filepath.Join(tempDir, "dir"), 0755))
+	writeTestFile(t, filepath.Join(tempDir, "dir"), "file", helloWorldString)
+	assert.Nil(t, os.Symlink("dir/file", filepath.Join(tempDir, "filelink")))
+	assert.Nil(t, os.Symlink("dir", filepath.Join(tempDir, "dirlink")))
+	assert.Nil(t, os.Symlink("..", filepath.Join(tempDir, "dir", "loop")))
+	assert.Nil(t, os.Symlink("missing", filepath.Join(tempDir, "broken")))
+	return tempDir
+}
+
+func TestHashPipelineRecordsSymlinks(t *testing.T) {
+	tempDir := setupSymlinkTree(t)
+	defer os.RemoveAll(tempDir)
+
+	records, err := newHashPipeline(tempDir, &Config{}, []string{"sha1"}).run()
+	assert.Nil(t, err)
+	assert.Len(t, records, 5)
+	assert.Equal(t, helloWorldChecksum, records["dir/file"].Checksum)
+	for relPath, target := range map[string]string{"filelink": "dir/file", "dirlink": "dir", "dir/loop": "..", "broken": "missing"} {
+		record := records[relPath]
+		assert.Equal(t, target, record.LinkTarget, relPath)
+		assert.True(t, record.linkOnly(), relPath)
+		assert.True(t, record.Mode&os.ModeSymlink != 0, relPath)
+	}
+}
+
+func TestHashPipelineFollowsSymlinks(t *testing.T) {
+	tempDir := setupSymlinkTree(t)
+	defer os.RemoveAll(tempDir)
+	assert.Nil(t, os.Link(filepath.Join(tempDir, "dir", "file"), filepath.Join(tempDir, "hardlink")))
+
+	pipeline := newHashPipeline(tempDir, &Config{FollowSymlinks: true}, []string{"sha1"})
+	records, err := pipeline.run()
+	assert.Nil(t, err)
+
+	// Links to files are hashed as the files
+	assert.Equal(t, helloWorldChecksum, records["filelink"].Checksum)
+	assert.Equal(t, "dir/file", records["filelink"].LinkTarget)
+	assert.Equal(t, int64(len(helloWorldString)), records["filelink"].Size)
+	// Linked directories are walked, and links that loop aren't followed
+	assert.Equal(t, "dir", records["dirlink"].LinkTarget)
+	assert.Equal(t, helloWorldChecksum, records["dirlink/file"].Checksum)
+	loop := records["dir/loop"]
+	assert.Equal(t, "..", loop.LinkTarget)
+	assert.True(t, loop.linkOnly())
+	assert.Equal(t, "..", records["dirlink/loop"].LinkTarget)
+	broken := records["broken"]
+	assert.Equal(t, "missing", broken.LinkTarget)
+	assert.True(t, broken.linkOnly())
+	assert.Len(t, records, 8)
+
+	// The file was only hashed for the first path it was found at
+	assert.Equal(t, map[string]fileAlias{
+		"dirlink/file": {path: "dir/file"},
+		"filelink":     {path: "dir/file", linkTarget: "dir/file"},
+		"hardlink":     {path: "dir/file"},
+	}, pipeline.aliases)
+	assert.Equal(t, "", records["hardlink"].LinkTarget)
+	assert.Equal(t, helloWorldChecksum, records["hardlink"].Checksum)
+}
+
+func TestManifestLinkAliases(t *testing.T) {
+	tempDir := setupSymlinkTree(t)
+	defer os.RemoveAll(tempDir)
+
+	manifest, err := NewManifest(tempDir, &Config{})
+	assert.Nil(t, err)
+	assert.False(t, manifest.followedSymlinks())
+	assert.Empty(t, manifest.linkAliases())
+
+	manifest, err = NewManifest(tempDir, &Config{FollowSymlinks: true})
+	assert.Nil(t, err)
+	assert.True(t, manifest.followedSymlinks())
+	// The file's own path is the one kept
+	assert.Equal(t, map[string]string{
+		"dirlink/file": "dir/file",
+		"filelink":     "dir/file",
+	}, manifest.linkAliases())
+}
+
+func TestManifestComparisonSymlinks(t *testing.T) {
+	modTime := time.Now()
+	oldManifest := &Manifest{
+		Entries: map[string]ChecksumRecord{
+			"same":       {LinkTarget: "a", ModTime: modTime},
+			"retargeted": {LinkTarget: "a", ModTime: modTime},
+			"deleted":    {LinkTarget: "a", ModTime: modTime},
+			"replaced":   {LinkTarget: "a", ModTime: modTime},
+			"followed":   {LinkTarget: "a", Checksum: "x", ModTime: modTime},
+			"file":       {Checksum: "y", ModTime: modTime},
+		},
+	}
+	newManifest := &Manifest{
+		Entries: map[string]ChecksumRecord{
+			"same":       {LinkTarget: "a", ModTime: modTime},
+			"retargeted": {LinkTarget: "b", ModTime: modTime},
+			"replaced":   {Checksum: "z", ModTime: modTime},
+			"followed":   {LinkTarget: "a", Checksum: "changed", ModTime: modTime},
+			"file":       {LinkTarget: "a", ModTime: modTime},
+			"added":      {LinkTarget: "c", ModTime: modTime},
+		},
+	}
+	comparison := CompareManifests(oldManifest, newManifest)
+	assert.ElementsMatch(t, []string{"added", "file"}, comparison.AddedLinks)
+	assert.ElementsMatch(t, []string{"deleted", "replaced"}, comparison.DeletedLinks)
+	assert.Equal(t, []string{"retargeted"}, comparison.RetargetedLinks)
+	assert.Equal(t, []string{"same"}, comparison.UnchangedPaths)
+	assert.Equal(t, []string{"replaced"}, comparison.AddedPaths)
+	assert.Equal(t, []string{"file"}, comparison.DeletedPaths)
+	// Followed links have their content checked
+	assert.Equal(t, []string{"followed"}, comparison.FlaggedPaths)
+	assert.Equal(t, 9, comparison.TotalChecked())
+
+	report := NewComparisonReport(comparison)
+	assert.Contains(t, report.SummaryString(), "Retargeted link paths: 1\n")
+	details := report.DetailString()
+	assert.Contains(t, details, "Retargeted link paths: 1\n    retargeted -> b (was a)\n")
+	assert.Contains(t, details, "    deleted -> a\n")
+	assert.Contains(t, details, "    added -> c\n")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Throttle limits how fast files are read, across all hashing workers, so that
+// a run leaves bandwidth for other users of the disks.
+type Throttle struct {
+	// Bytes per second; zero for no limit
+	MaxReadRate float64
+	// Files opened per second; zero for no limit
+	MaxFilesPerSecond float64
+	bytes             rateLimiter
+	files             rateLimiter
+	mutex             sync.Mutex
+	// Workers currently waiting, and since when at least one has been
+	waiting   int
+	waitStart time.Time
+	waited    time.Duration
+}
+
+// NewThrottle returns a throttle with the given limits, or nil if there are
+// none.
+func NewThrottle(maxReadRate, maxFilesPerSecond float64) *Throttle {
+	if maxReadRate <= 0 && maxFilesPerSecond <= 0 {
+		return nil
+	}
+	return &Throttle{
+		MaxReadRate:       maxReadRate,
+		MaxFilesPerSecond: maxFilesPerSecond,
+		bytes:             rateLimiter{rate: maxReadRate},
+		files:             rateLimiter{rate: maxFilesPerSecond},
+	}
+}
+
+// waitForFile blocks until another file may be opened.
+func (t *Throttle) waitForFile() {
+	if t != nil {
+		t.sleep(t.files.reserve(1))
+	}
+}
+
+// chargeRead counts n bytes that were just read against the limit, blocking
+// until earlier reads are paid for. Reads are charged afterwards so that only
+// bytes actually read count, however large the buffer.
+func (t *Throttle) chargeRead(n int) {
+	if t != nil {
+		t.sleep(t.bytes.reserve(float64(n)))
+	}
+}
+
+// sleep waits on a limit, keeping track of the time any worker was waiting.
+func (t *Throttle) sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t.mutex.Lock()
+	if t.waiting == 0 {
+		t.waitStart = time.Now()
+	}
+	t.waiting++
+	t.mutex.Unlock()
+
+	time.Sleep(d)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.waiting--
+	if t.waiting == 0 {
+		t.waited += time.Since(t.waitStart)
+	}
+}
+
+// Waited returns how long workers were held up by the limits: the time during
+// which at least one was waiting, so waits that overlap count once.
+func (t *Throttle) Waited() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.waited
+}
+
+// String describes the limits.
+func (t *Throttle) String() string {
+	limits := []string{}
+	if t.MaxReadRate > 0 {
+		limits = append(limits, fmt.Sprintf("%s/s", ByteSize(t.MaxReadRate)))
+	}
+	if t.MaxFilesPerSecond > 0 {
+		limits = append(limits, fmt.Sprintf("%g files/s", t.MaxFilesPerSecond))
+	}
+	return strings.Join(limits, ", ")
+}
+
+// rateLimiter spaces out uses of a resource so they average at most rate units
+// per second. A use may start immediately if the limiter has been idle, and
+// later uses are delayed to pay for it.
+type rateLimiter struct {
+	rate  float64
+	mutex sync.Mutex
+	// When the next use may start
+	next time.Time
+}
+
+// reserve books n units and returns how long to wait before using them.
+func (l *rateLimiter) reserve(n float64) time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	l.next = start.Add(time.Duration(n / l.rate * float64(time.Second)))
+	l.mutex.Unlock()
+	return start.Sub(now)
+}
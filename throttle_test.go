@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := &rateLimiter{rate: 100}
+	// The first use starts immediately; the rest wait for the ones before
+	assert.Equal(t, time.Duration(0), limiter.reserve(5))
+	assert.InDelta(t, 50*time.Millisecond, limiter.reserve(5), float64(10*time.Millisecond))
+	assert.InDelta(t, 100*time.Millisecond, limiter.reserve(5), float64(10*time.Millisecond))
+
+	unlimited := &rateLimiter{}
+	assert.Equal(t, time.Duration(0), unlimited.reserve(1e9))
+}
+
+func TestThrottle(t *testing.T) {
+	assert.Nil(t, NewThrottle(0, 0))
+	var none *Throttle
+	none.waitForFile()
+	none.chargeRead(1 << 30)
+
+	throttle := NewThrottle(2*1024*1024, 20)
+	assert.Equal(t, "2.0 MiB/s, 20 files/s", throttle.String())
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		throttle.waitForFile()
+	}
+	throttle.chargeRead(1024 * 1024)
+	throttle.chargeRead(1024 * 1024)
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 500*time.Millisecond)
+	assert.Greater(t, throttle.Waited(), 500*time.Millisecond)
+	assert.Equal(t, "5 files/s", NewThrottle(0, 5).String())
+
+	// Workers waiting at the same time count once
+	throttle = NewThrottle(0, 10)
+	throttle.waitForFile()
+	start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			throttle.waitForFile()
+		}()
+	}
+	wg.Wait()
+	elapsed = time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 300*time.Millisecond)
+	assert.LessOrEqual(t, throttle.Waited(), elapsed)
+}
+
+func TestThrottleChargesBytesRead(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "throttle")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	writeTestFile(t, tempDir, "small", "x")
+
+	// Small files don't pay for the whole buffer
+	throttle := NewThrottle(1024, 0)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, _, err = hashFile(filepath.Join(tempDir, "small"), []string{"sha1"}, hashOptions{throttle: throttle})
+		assert.Nil(t, err)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+}
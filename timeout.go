@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dirEntry is a directory entry with the result of examining it.
+type dirEntry struct {
+	name string
+	info os.FileInfo
+	err  error
+}
+
+// withDeadline runs read on its own goroutine and waits up to timeout for it
+// to finish, or indefinitely if timeout is zero. A read that takes too long is
+// abandoned: it's left blocked, e.g. on a hung network mount, and whatever it
+// returns is ignored, so it mustn't share results with the caller except
+// through its return value.
+func withDeadline(timeout time.Duration, read func() error) error {
+	if timeout <= 0 {
+		return read()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- read()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// readDirEntries lists a directory and examines each entry, sorted by name,
+// giving up after timeout if nonzero.
+func readDirEntries(dir string, timeout time.Duration) ([]dirEntry, error) {
+	results := make(chan []dirEntry, 1)
+	err := withDeadline(timeout, func() error {
+		file, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		names, err := file.Readdirnames(-1)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+		entries := make([]dirEntry, len(names))
+		for i, name := range names {
+			info, err := os.Lstat(filepath.Join(dir, name))
+			entries[i] = dirEntry{name: name, info: info, err: err}
+		}
+		results <- entries
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return <-results, nil
+}
+
+// stallWatch tracks whether a read is making progress, so it can be given up
+// on once it stops without counting time it spends waiting on purpose, such as
+// for read limits. Its methods do nothing on a nil watch.
+type stallWatch struct {
+	mutex sync.Mutex
+	// When the read last got somewhere
+	last time.Time
+	// Waits in progress, during which the read isn't stalled
+	paused int
+}
+
+func newStallWatch() *stallWatch {
+	return &stallWatch{last: time.Now()}
+}
+
+// progress records that the read got somewhere.
+func (w *stallWatch) progress() {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.last = time.Now()
+}
+
+// pause stops the clock while the read waits on purpose, until resume.
+func (w *stallWatch) pause() {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.paused++
+}
+
+func (w *stallWatch) resume() {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.paused--
+	w.last = time.Now()
+}
+
+// stalledFor returns how long the read has gone without progress.
+func (w *stallWatch) stalledFor() time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.paused > 0 {
+		return 0
+	}
+	return time.Since(w.last)
+}
+
+// withStallTimeout runs read on its own goroutine like withDeadline, but only
+// gives up once watch has seen no progress for timeout.
+func withStallTimeout(timeout time.Duration, watch *stallWatch, read func() error) error {
+	if timeout <= 0 {
+		return read()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- read()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-timer.C:
+			stalled := watch.stalledFor()
+			if stalled >= timeout {
+				return fmt.Errorf("stalled for %s", timeout)
+			}
+			timer.Reset(timeout - stalled)
+		}
+	}
+}
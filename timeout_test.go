@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDeadline(t *testing.T) {
+	errRead := errors.New("read failed")
+	assert.Equal(t, errRead, withDeadline(0, func() error { return errRead }))
+	assert.Equal(t, errRead, withDeadline(time.Minute, func() error { return errRead }))
+
+	// A read that never finishes is abandoned
+	hung := make(chan struct{})
+	defer close(hung)
+	err := withDeadline(10*time.Millisecond, func() error {
+		<-hung
+		return nil
+	})
+	assert.EqualError(t, err, "timed out after 10ms")
+}
+
+func TestReadDirEntries(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "timeout")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	writeTestFile(t, tempDir, "b", "b")
+	writeTestFile(t, tempDir, "a", "a")
+	assert.Nil(t, os.Mkdir(filepath.Join(tempDir, "c"), 0755))
+
+	entries, err := readDirEntries(tempDir, time.Minute)
+	assert.Nil(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		assert.Nil(t, entry.err)
+		names = append(names, entry.name)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+	assert.True(t, entries[2].info.IsDir())
+
+	_, err = readDirEntries(filepath.Join(tempDir, "missing"), time.Minute)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWithStallTimeout(t *testing.T) {
+	errRead := errors.New("read failed")
+	assert.Equal(t, errRead, withStallTimeout(0, nil, func() error { return errRead }))
+
+	// Reads that keep making progress, or are waiting on purpose, may take
+	// longer than the timeout
+	watch := newStallWatch()
+	err := withStallTimeout(20*time.Millisecond, watch, func() error {
+		for i := 0; i < 5; i++ {
+			time.Sleep(10 * time.Millisecond)
+			watch.progress()
+		}
+		watch.pause()
+		time.Sleep(50 * time.Millisecond)
+		watch.resume()
+		return nil
+	})
+	assert.Nil(t, err)
+
+	hung := make(chan struct{})
+	defer close(hung)
+	err = withStallTimeout(10*time.Millisecond, newStallWatch(), func() error {
+		<-hung
+		return nil
+	})
+	assert.EqualError(t, err, "stalled for 10ms")
+}
+
+func TestHashPipelineTimeoutExcludesThrottling(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "timeout")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	content := strings.Repeat("x", 10)
+	writeTestFile(t, tempDir, "a", content)
+	writeTestFile(t, tempDir, "b", content)
+
+	// Each file waits longer than the timeout on the read limit
+	config := &Config{Jobs: 1, Throttle: NewThrottle(100, 0), FileTimeout: 50 * time.Millisecond}
+	records, err := newHashPipeline(tempDir, config, []string{"sha1"}).run()
+	assert.Nil(t, err)
+	for _, relPath := range []string{"a", "b"} {
+		assert.Equal(t, "", records[relPath].Unreadable, relPath)
+		assert.NotEqual(t, "", records[relPath].Checksum, relPath)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build unix
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashPipelineTimesOutOnIgnoreFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "timeout")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	writeTestFile(t, tempDir, "ok", helloWorldString)
+	assert.Nil(t, os.Mkdir(filepath.Join(tempDir, "hung"), 0755))
+	writeTestFile(t, filepath.Join(tempDir, "hung"), "file", helloWorldString)
+	// Opening a FIFO blocks until there's a writer, as on a hung mount
+	fifo := filepath.Join(tempDir, "hung", ignoreFileName)
+	assert.Nil(t, syscall.Mkfifo(fifo, 0644))
+	defer func() {
+		// Lets the abandoned open finish
+		if writer, err := os.OpenFile(fifo, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			writer.Close()
+		}
+	}()
+
+	pipeline := newHashPipeline(tempDir, &Config{DirTimeout: 50 * time.Millisecond}, []string{"sha1"})
+	records, err := pipeline.run()
+	assert.Nil(t, err)
+	assert.Equal(t, helloWorldChecksum, records["ok"].Checksum)
+	assert.NotContains(t, records, "hung/file")
+	assert.Equal(t, map[string]string{"hung": "timed out after 50ms"}, pipeline.unreadableDirs)
+}
+
+func TestHashPipelineFailsFastOnStalledDevice(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "timeout")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	writeTestFile(t, tempDir, "file", helloWorldString)
+	hung := filepath.Join(tempDir, "hung")
+	assert.Nil(t, syscall.Mkfifo(hung, 0644))
+	unblock := func() {
+		if writer, err := os.OpenFile(hung, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			writer.Close()
+		}
+	}
+	defer unblock()
+	hungInfo, err := os.Lstat(hung)
+	assert.Nil(t, err)
+	fileInfo, err := os.Lstat(filepath.Join(tempDir, "file"))
+	assert.Nil(t, err)
+	fileJob := hashJob{path: filepath.Join(tempDir, "file"), relPath: "file", info: fileInfo}
+
+	pipeline := newHashPipeline(tempDir, &Config{Jobs: 1, FileTimeout: 50 * time.Millisecond}, []string{"sha1"})
+	queue := pipeline.queueFor(fileInfo)
+	defer func() {
+		close(queue)
+		pipeline.workers.Wait()
+	}()
+
+	// Other files on the device aren't read while one is stuck
+	queue <- hashJob{path: hung, relPath: "hung", info: hungInfo}
+	queue <- fileJob
+	assert.Equal(t, "stalled for 50ms", (<-pipeline.results).record.Unreadable)
+	assert.Equal(t, "device stalled reading hung", (<-pipeline.results).record.Unreadable)
+
+	// Once the stuck read returns, they are again
+	unblock()
+	var record ChecksumRecord
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		queue <- fileJob
+		record = (<-pipeline.results).record
+		if record.Unreadable == "" {
+			break
+		}
+	}
+	assert.Equal(t, helloWorldChecksum, record.Checksum)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// describeReadError says why a file or directory couldn't be read, without
+// repeating its path.
+func describeReadError(err error) string {
+	if os.IsNotExist(err) {
+		return "vanished"
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Op + ": " + pathErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// neverRead reports whether a record is for a file that has never been read,
+// so has no checksum.
+func (r *ChecksumRecord) neverRead() bool {
+	return r.unverified() && r.Checksum == ""
+}
+
+// Returns a record for a file that couldn't be read, with its metadata if it
+// could at least be examined.
+func unreadableRecord(info os.FileInfo, err error) ChecksumRecord {
+	record := ChecksumRecord{Unreadable: describeReadError(err)}
+	if info != nil {
+		record.ModTime = info.ModTime().UTC()
+		record.Size = info.Size()
+		record.Mode = info.Mode()
+	}
+	return record
+}
+
+// keepUnverified gives files that couldn't be read or kept changing their
+// records from the baseline manifest (if they're in it), marked with why they
+// weren't verified, so that their checksums aren't lost. Files in the baseline
+// under directories that couldn't be read, given by relative path with the
+// reason, are kept the same way.
+func (m *Manifest) keepUnverified(baseline *Manifest, dirs map[string]string) {
+	for relPath, entry := range m.Entries {
+		if !entry.unverified() || baseline == nil {
+			continue
+		}
+		if _, ok := baseline.Entries[relPath]; ok {
+			record := previousRecord(baseline, m, relPath, entry.Unreadable)
+			record.Unstable = entry.Unstable
+			m.Entries[relPath] = record
+		}
+	}
+	if baseline == nil {
+		return
+	}
+	for dir, reason := range dirs {
+		prefix := dir + string(filepath.Separator)
+		if dir == "." {
+			prefix = ""
+		}
+		for relPath := range baseline.Entries {
+			if _, ok := m.Entries[relPath]; ok || !strings.HasPrefix(relPath, prefix) {
+				continue
+			}
+			m.Entries[relPath] = previousRecord(baseline, m, relPath, reason)
+		}
+	}
+}
+
+// Returns the baseline record for a file that wasn't verified, with the
+// reason it couldn't be read, if that's why.
+func previousRecord(baseline, manifest *Manifest, relPath, reason string) ChecksumRecord {
+	record := baselineRecord(baseline, manifest, relPath)
+	record.Unreadable = reason
+	record.Unstable = false
+	// Not verified by this run
+	record.CarriedForward = true
+	return record
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeReadError(t *testing.T) {
+	assert.Equal(t, "vanished", describeReadError(&os.PathError{Op: "lstat", Path: "/x", Err: syscall.ENOENT}))
+	assert.Equal(t, "open: permission denied", describeReadError(&os.PathError{Op: "open", Path: "/x", Err: syscall.EACCES}))
+	assert.Equal(t, "read: input/output error", describeReadError(&os.PathError{Op: "read", Path: "/x", Err: syscall.EIO}))
+	assert.Equal(t, "timed out", describeReadError(errors.New("timed out")))
+}
+
+func TestKeepUnreadable(t *testing.T) {
+	modTime := time.Now().UTC()
+	baseline := &Manifest{
+		Algorithm: "sha1",
+		Entries: map[string]ChecksumRecord{
+			"ok":          {Checksum: "a", ModTime: modTime},
+			"bad":         {Checksum: "b", ModTime: modTime, Size: 10},
+			"locked/file": {Checksum: "c", ModTime: modTime},
+			"gone":        {Checksum: "d", ModTime: modTime},
+		},
+	}
+	manifest := &Manifest{
+		Algorithm: "sha1",
+		Entries: map[string]ChecksumRecord{
+			"ok":  {Checksum: "a", ModTime: modTime},
+			"bad": {Unreadable: "read: input/output error"},
+			"new": {Unreadable: "open: permission denied", Size: 5},
+		},
+	}
+	manifest.keepUnverified(baseline, map[string]string{"locked": "open: permission denied"})
+
+	assert.Equal(t, ChecksumRecord{Checksum: "b", ModTime: modTime, Size: 10, CarriedForward: true, Unreadable: "read: input/output error"}, manifest.Entries["bad"])
+	assert.Equal(t, ChecksumRecord{Checksum: "c", ModTime: modTime, CarriedForward: true, Unreadable: "open: permission denied"}, manifest.Entries["locked/file"])
+	// Never read, so nothing to keep
+	assert.Equal(t, ChecksumRecord{Unreadable: "open: permission denied", Size: 5}, manifest.Entries["new"])
+	newEntry := manifest.Entries["new"]
+	assert.True(t, newEntry.neverRead())
+	// Deleted files aren't brought back
+	assert.NotContains(t, manifest.Entries, "gone")
+
+	comparison := CompareManifests(baseline, manifest)
+	assert.False(t, comparison.Success())
+	assert.ElementsMatch(t, []string{"bad", "new", "locked/file"}, comparison.UnreadablePaths)
+	assert.Equal(t, []string{"ok"}, comparison.UnchangedPaths)
+	assert.Equal(t, []string{"gone"}, comparison.DeletedPaths)
+	assert.Empty(t, comparison.AddedPaths)
+	assert.Equal(t, "read: input/output error", comparison.UnreadableReason("bad"))
+
+	// Once it can be read, a file that never was counts as added
+	readable := &Manifest{
+		Algorithm: "sha1",
+		Entries: map[string]ChecksumRecord{
+			"new": {Checksum: "e", ModTime: modTime, Size: 5},
+		},
+	}
+	comparison = CompareManifests(manifest, readable)
+	assert.Equal(t, []string{"new"}, comparison.AddedPaths)
+	assert.Empty(t, comparison.RenamedPaths)
+}
+
+func TestHashPipelineContinuesPastErrors(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "unreadable")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	assert.Nil(t, os.Mkdir(filepath.Join(tempDir, "locked"), 0755))
+	info, err := os.Stat(filepath.Join(tempDir, "locked"))
+	assert.Nil(t, err)
+
+	pipeline := newHashPipeline(tempDir, DefaultConfig(), []string{"sha1"})
+	pipeline.ignores, err = newIgnoreMatcher(tempDir, DefaultConfig())
+	assert.Nil(t, err)
+	results := make(chan hashResult, 1)
+	go func() {
+		results <- <-pipeline.results
+	}()
+
+	// As filepath.Walk reports a file that vanished after being listed
+	err = pipeline.visit(filepath.Join(tempDir, "gone"), nil, &os.PathError{Op: "lstat", Err: syscall.ENOENT})
+	assert.Nil(t, err)
+	assert.Equal(t, hashResult{relPath: "gone", record: ChecksumRecord{Unreadable: "vanished"}}, <-results)
+
+	// And a directory that can't be listed
+	err = pipeline.visit(filepath.Join(tempDir, "locked"), info, &os.PathError{Op: "open", Err: syscall.EACCES})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"locked": "open: permission denied"}, pipeline.unreadableDirs)
+
+	// Or one whose ignore file can't be read
+	assert.Nil(t, os.MkdirAll(filepath.Join(tempDir, "badignore", ignoreFileName), 0755))
+	info, err = os.Stat(filepath.Join(tempDir, "badignore"))
+	assert.Nil(t, err)
+	err = pipeline.visit(filepath.Join(tempDir, "badignore"), info, nil)
+	assert.Equal(t, filepath.SkipDir, err)
+	assert.Equal(t, "read: is a directory", pipeline.unreadableDirs["badignore"])
+
+	// Without the root there's nothing to do
+	err = pipeline.visit(tempDir, nil, &os.PathError{Op: "lstat", Err: syscall.ENOENT})
+	assert.NotNil(t, err)
+}
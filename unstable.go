@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Times a file that changed while being hashed is hashed again before it's
+// recorded as unstable
+const unstableRetries = 3
+
+// Wait before hashing a file again, to give whatever is writing it a chance
+// to finish
+const unstableRetryDelay = 100 * time.Millisecond
+
+// hashStableFile hashes a file, checking that it didn't change while it was
+// read. A file that keeps changing gets an unstable record rather than a
+// checksum that matches neither its old nor its new content.
+func hashStableFile(path string, algorithms []string, options hashOptions) (ChecksumRecord, error) {
+	var after os.FileInfo
+	for attempt := 0; attempt <= unstableRetries; attempt++ {
+		if attempt > 0 {
+			options.watch.pause()
+			time.Sleep(unstableRetryDelay)
+			options.watch.resume()
+		}
+		before, err := os.Stat(path)
+		if err != nil {
+			return ChecksumRecord{}, err
+		}
+		options.watch.progress()
+		checksums, chunks, err := hashFile(path, algorithms, options)
+		if err != nil {
+			return ChecksumRecord{}, err
+		}
+		after, err = os.Stat(path)
+		if err != nil {
+			return ChecksumRecord{}, err
+		}
+		if sameFileState(before, after) {
+			record := newChecksumRecord(after, algorithms, checksums)
+			record.Chunks = chunks
+			return record, nil
+		}
+	}
+	return unstableRecord(after), nil
+}
+
+// sameFileState reports whether two stats of a file show the same size,
+// modification and change times, and inode.
+func sameFileState(before, after os.FileInfo) bool {
+	beforeStat := statFromInfo(before)
+	afterStat := statFromInfo(after)
+	return before.Size() == after.Size() &&
+		before.ModTime().Equal(after.ModTime()) &&
+		beforeStat.CTime.Equal(afterStat.CTime) &&
+		beforeStat.Inode == afterStat.Inode
+}
+
+// Returns a record for a file that kept changing while it was hashed.
+func unstableRecord(info os.FileInfo) ChecksumRecord {
+	return ChecksumRecord{
+		ModTime:  info.ModTime().UTC(),
+		Size:     info.Size(),
+		Mode:     info.Mode(),
+		Unstable: true,
+	}
+}
+
+// unverified reports whether a record's checksums weren't read from the file
+// by the run that made it, because it couldn't be read or kept changing.
+func (r *ChecksumRecord) unverified() bool {
+	return r.Unreadable != "" || r.Unstable
+}
+
+// UnstableCount returns the number of entries for files that kept changing
+// while they were hashed.
+func (m *Manifest) UnstableCount() int {
+	count := 0
+	for _, entry := range m.Entries {
+		if entry.Unstable {
+			count++
+		}
+	}
+	return count
+}
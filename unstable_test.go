@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashStableFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "unstable")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "file")
+	content := []byte("stable")
+	assert.Nil(t, ioutil.WriteFile(path, content, 0644))
+
+	record, err := hashStableFile(path, []string{"sha1"}, hashOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, checksumHexString(&content), record.Checksum)
+	assert.Equal(t, int64(6), record.Size)
+	assert.False(t, record.Unstable)
+
+	_, err = hashStableFile(filepath.Join(tempDir, "missing"), []string{"sha1"}, hashOptions{})
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHashStableFileWhileWritten(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "unstable")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "file")
+	assert.Nil(t, ioutil.WriteFile(path, bytes.Repeat([]byte("x"), 4*1024*1024), 0644))
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	defer file.Close()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				file.Write([]byte("y"))
+			}
+		}
+	}()
+
+	// Reading slowly gives the writer a chance to run between reads
+	throttle := NewThrottle(64*1024*1024, 0)
+	record, err := hashStableFile(path, []string{"sha1"}, hashOptions{throttle: throttle})
+	close(stop)
+	<-stopped
+	assert.Nil(t, err)
+	assert.True(t, record.Unstable)
+	assert.Equal(t, "", record.Checksum)
+}
+
+func TestKeepUnverifiedUnstable(t *testing.T) {
+	modTime := time.Now().UTC()
+	baseline := &Manifest{
+		Algorithm: "sha1",
+		Entries: map[string]ChecksumRecord{
+			"ok":      {Checksum: "a", ModTime: modTime},
+			"growing": {Checksum: "b", ModTime: modTime, Size: 10},
+		},
+	}
+	manifest := &Manifest{
+		Algorithm: "sha1",
+		Entries: map[string]ChecksumRecord{
+			"ok":      {Checksum: "a", ModTime: modTime},
+			"growing": {Unstable: true, ModTime: modTime.Add(time.Second), Size: 20},
+			"new":     {Unstable: true, Size: 5},
+		},
+	}
+	manifest.keepUnverified(baseline, nil)
+
+	assert.Equal(t, ChecksumRecord{Checksum: "b", ModTime: modTime, Size: 10, CarriedForward: true, Unstable: true}, manifest.Entries["growing"])
+	assert.Equal(t, ChecksumRecord{Unstable: true, Size: 5}, manifest.Entries["new"])
+	assert.Equal(t, 2, manifest.UnstableCount())
+
+	// Neither flagged nor a failure
+	comparison := CompareManifests(baseline, manifest)
+	assert.True(t, comparison.Success())
+	assert.ElementsMatch(t, []string{"growing", "new"}, comparison.UnstablePaths)
+	assert.Equal(t, []string{"ok"}, comparison.UnchangedPaths)
+	assert.Empty(t, comparison.FlaggedPaths)
+	assert.Empty(t, comparison.AddedPaths)
+	assert.Equal(t, 3, comparison.TotalChecked())
+
+	// Always re-hashed by the next run
+	_, ok := carryForward(manifest, "growing", nil, []string{"sha1"}, 0)
+	assert.False(t, ok)
+}
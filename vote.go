@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// Verdicts for a file voted on across replicas
+const (
+	// All copies are the same
+	verdictUnanimous = "unanimous"
+	// More copies have one content than any other
+	verdictMajority = "majority"
+	// Copies are split evenly, but only one content matches a stored manifest
+	verdictTiebreak  = "tiebreak"
+	verdictUndecided = "undecided"
+)
+
+// FileVote is the verdict on one file's copies across replicas. Replicas are
+// identified by their paths.
+type FileVote struct {
+	Path    string
+	Verdict string
+	// Checksum of the content judged correct, if decided
+	Checksum string
+	// Replicas holding that content
+	Good []string
+	// Replicas whose copies differ from it (or, if undecided, all replicas
+	// with the file)
+	Corrupt []string
+	// Replicas without the file
+	Missing []string
+}
+
+// Decided reports whether the correct content could be determined.
+func (v *FileVote) Decided() bool {
+	return v.Verdict != verdictUndecided
+}
+
+// VoteOnReplicas decides the correct content of each file found in any of the
+// replicas' manifests, which must use the same hash algorithm. Each replica's
+// stored manifest (or nil) breaks ties between equal numbers of copies.
+func VoteOnReplicas(replicas []*Manifest, stored []*Manifest) []*FileVote {
+	algorithm := replicas[0].HashAlgorithm()
+	paths := map[string]bool{}
+	for _, replica := range replicas {
+		for relPath, entry := range replica.Entries {
+			// Symlinks that weren't followed have no content to vote on
+			if !entry.linkOnly() {
+				paths[relPath] = true
+			}
+		}
+	}
+	sortedPaths := []string{}
+	for relPath := range paths {
+		sortedPaths = append(sortedPaths, relPath)
+	}
+	sort.Strings(sortedPaths)
+
+	votes := []*FileVote{}
+	for _, relPath := range sortedPaths {
+		vote := &FileVote{Path: relPath}
+		// Replicas holding each distinct content
+		holders := map[string][]string{}
+		for _, replica := range replicas {
+			entry, ok := replica.Entries[relPath]
+			// An unreadable or unstable copy, or a symlink, can't vote
+			if !ok || entry.unverified() || entry.linkOnly() {
+				vote.Missing = append(vote.Missing, replica.Path)
+				continue
+			}
+			holders[entry.Checksum] = append(holders[entry.Checksum], replica.Path)
+		}
+		vote.Verdict, vote.Checksum = decideVote(holders, storedChecksums(stored, relPath, algorithm))
+		for checksum, replicaPaths := range holders {
+			if vote.Decided() && checksum == vote.Checksum {
+				vote.Good = replicaPaths
+			} else {
+				vote.Corrupt = append(vote.Corrupt, replicaPaths...)
+			}
+		}
+		sort.Strings(vote.Corrupt)
+		votes = append(votes, vote)
+	}
+	return votes
+}
+
+// Picks the content with the most copies, falling back on stored checksums to
+// choose between contents with equally many.
+func decideVote(holders map[string][]string, stored map[string]bool) (string, string) {
+	if len(holders) == 1 {
+		for checksum := range holders {
+			return verdictUnanimous, checksum
+		}
+	}
+	most := 0
+	leaders := []string{}
+	for checksum, replicaPaths := range holders {
+		if len(replicaPaths) > most {
+			most = len(replicaPaths)
+			leaders = []string{checksum}
+		} else if len(replicaPaths) == most {
+			leaders = append(leaders, checksum)
+		}
+	}
+	if len(leaders) == 1 {
+		return verdictMajority, leaders[0]
+	}
+	known := []string{}
+	for _, checksum := range leaders {
+		if stored[checksum] {
+			known = append(known, checksum)
+		}
+	}
+	if len(known) == 1 {
+		return verdictTiebreak, known[0]
+	}
+	return verdictUndecided, ""
+}
+
+// Checksums recorded for a path in the given algorithm by any of the stored
+// manifests.
+func storedChecksums(stored []*Manifest, relPath, algorithm string) map[string]bool {
+	checksums := map[string]bool{}
+	for _, manifest := range stored {
+		if manifest == nil {
+			continue
+		}
+		entry, ok := manifest.Entries[relPath]
+		if !ok {
+			continue
+		}
+		if sum, ok := entry.checksumFor(manifest.HashAlgorithm(), algorithm); ok && sum != "" {
+			checksums[sum] = true
+		}
+	}
+	return checksums
+}
+
+// VoteReport summarizes the verdicts, listing files that weren't unanimous.
+func VoteReport(votes []*FileVote) string {
+	byVerdict := map[string][]*FileVote{}
+	for _, vote := range votes {
+		byVerdict[vote.Verdict] = append(byVerdict[vote.Verdict], vote)
+	}
+
+	s := fmt.Sprintf("%d files compared.\n\n", len(votes))
+	s += fmt.Sprintf("Unanimous paths: %d\n", len(byVerdict[verdictUnanimous]))
+	for _, vote := range byVerdict[verdictUnanimous] {
+		if len(vote.Missing) > 0 {
+			s += fmt.Sprintf("    %s (missing from %s)\n", vote.Path, quotedList(vote.Missing))
+		}
+	}
+	s += fmt.Sprintf("Majority paths: %d\n", len(byVerdict[verdictMajority]))
+	for _, vote := range byVerdict[verdictMajority] {
+		s += fmt.Sprintf("    %s (%d of %d copies agree; likely corrupt in %s)\n",
+			vote.Path, len(vote.Good), len(vote.Good)+len(vote.Corrupt), quotedList(vote.Corrupt))
+	}
+	s += fmt.Sprintf("Tiebreak paths: %d\n", len(byVerdict[verdictTiebreak]))
+	for _, vote := range byVerdict[verdictTiebreak] {
+		s += fmt.Sprintf("    %s (%d of %d copies match a stored manifest; likely corrupt in %s)\n",
+			vote.Path, len(vote.Good), len(vote.Good)+len(vote.Corrupt), quotedList(vote.Corrupt))
+	}
+	s += fmt.Sprintf("Undecided paths: %d\n", len(byVerdict[verdictUndecided]))
+	for _, vote := range byVerdict[verdictUndecided] {
+		s += fmt.Sprintf("    %s (no majority among copies in %s)\n", vote.Path, quotedList(vote.Corrupt))
+	}
+	return s
+}
+
+// VoteRepairPlan lists copies to make to replace likely corrupt files with
+// copies judged correct.
+func VoteRepairPlan(votes []*FileVote) []string {
+	plan := []string{}
+	for _, vote := range votes {
+		if !vote.Decided() || len(vote.Corrupt) == 0 {
+			continue
+		}
+		source := filepath.Join(vote.Good[0], vote.Path)
+		for _, replica := range vote.Corrupt {
+			plan = append(plan, fmt.Sprintf("copy %s -> %s", source, filepath.Join(replica, vote.Path)))
+		}
+	}
+	return plan
+}
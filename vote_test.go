@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func voteTestManifest(path string, checksums map[string]string) *Manifest {
+	manifest := &Manifest{Path: path, Entries: map[string]ChecksumRecord{}}
+	for relPath, checksum := range checksums {
+		manifest.Entries[relPath] = ChecksumRecord{Checksum: checksum}
+	}
+	return manifest
+}
+
+func TestVoteOnReplicas(t *testing.T) {
+	replicas := []*Manifest{
+		voteTestManifest("/a", map[string]string{"same": "1", "rotted": "1", "split": "1", "missing": "1"}),
+		voteTestManifest("/b", map[string]string{"same": "1", "rotted": "2", "split": "2", "missing": "1"}),
+		voteTestManifest("/c", map[string]string{"same": "1", "rotted": "1", "split": "3"}),
+	}
+	votes := VoteOnReplicas(replicas, []*Manifest{nil, nil, nil})
+	byPath := map[string]*FileVote{}
+	for _, vote := range votes {
+		byPath[vote.Path] = vote
+	}
+	assert.Len(t, votes, 4)
+
+	assert.Equal(t, verdictUnanimous, byPath["same"].Verdict)
+	assert.Empty(t, byPath["same"].Corrupt)
+
+	assert.Equal(t, verdictMajority, byPath["rotted"].Verdict)
+	assert.Equal(t, "1", byPath["rotted"].Checksum)
+	assert.Equal(t, []string{"/a", "/c"}, byPath["rotted"].Good)
+	assert.Equal(t, []string{"/b"}, byPath["rotted"].Corrupt)
+
+	assert.Equal(t, verdictUndecided, byPath["split"].Verdict)
+	assert.Equal(t, []string{"/a", "/b", "/c"}, byPath["split"].Corrupt)
+
+	assert.Equal(t, verdictUnanimous, byPath["missing"].Verdict)
+	assert.Equal(t, []string{"/c"}, byPath["missing"].Missing)
+
+	assert.Equal(t, []string{"copy /a/rotted -> /b/rotted"}, VoteRepairPlan(votes))
+	report := VoteReport(votes)
+	assert.Contains(t, report, "Majority paths: 1\n    rotted (2 of 3 copies agree; likely corrupt in \"/b\")\n")
+	assert.Contains(t, report, "Undecided paths: 1\n    split")
+	assert.Contains(t, report, "    missing (missing from \"/c\")\n")
+}
+
+func TestVoteOnReplicasTiebreak(t *testing.T) {
+	replicas := []*Manifest{
+		voteTestManifest("/a", map[string]string{"foo": "good", "bar": "x"}),
+		voteTestManifest("/b", map[string]string{"foo": "bad", "bar": "y"}),
+	}
+	stored := []*Manifest{
+		nil,
+		// Stored checksums in another algorithm are used through alternates
+		{
+			Algorithm: "sha256",
+			Entries: map[string]ChecksumRecord{
+				"foo": {Checksum: "sha256sum", AltChecksums: map[string]string{"sha1": "good"}},
+				"bar": {Checksum: "sha256sum"},
+			},
+		},
+	}
+	votes := VoteOnReplicas(replicas, stored)
+	assert.Equal(t, "bar", votes[0].Path)
+	assert.Equal(t, verdictUndecided, votes[0].Verdict)
+	assert.Equal(t, "foo", votes[1].Path)
+	assert.Equal(t, verdictTiebreak, votes[1].Verdict)
+	assert.Equal(t, []string{"/a"}, votes[1].Good)
+	assert.Equal(t, []string{"/b"}, votes[1].Corrupt)
+}